@@ -1,78 +1,549 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"SBCFAA/internal/fare"
 	"SBCFAA/internal/ingestion"
+	"SBCFAA/internal/models"
 	"SBCFAA/internal/output"
+	"SBCFAA/pkg/utils"
+)
+
+// stdoutOutput is the -output value that writes to standard output
+const stdoutOutput = "-"
+
+// Exit codes. 2 is left to the flag package for usage errors.
+const (
+	exitOK              = 0
+	exitInputError      = 3   // bad arguments or tariff, or the input could not be read at all
+	exitProcessingError = 4   // the pipeline stopped part way through the input
+	exitOutputError     = 5   // results, rejects or profiles could not be written
+	exitInterrupted     = 130 // stopped by SIGINT or SIGTERM before finishing
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	// Logs go to stderr, leaving stdout to the results with -output -
+	log.SetOutput(os.Stderr)
+
 	// command-line flags
-	inputFile := flag.String("input", "", "Input CSV file path")
-	outputFile := flag.String("output", "fare_estimates.csv", "Output CSV file path")
+	var inputArgs inputList
+	flag.Var(&inputArgs, "input", "Input file (CSV, NDJSON or GPX), glob, directory or - for standard input, optionally gzip, zstd or bzip2 compressed; repeat for several (further inputs may also follow the flags)")
+	inputFormat := flag.String("input-format", "auto", "Input format: auto (from each file's extension), csv, ndjson or gpx")
+	outputFile := flag.String("output", "fare_estimates.csv", "Output CSV file path, or - for standard output")
+	tariffFile := flag.String("tariff", "", "Tariff file (JSON or YAML); built-in rates are used when empty")
+	zonesFile := flag.String("zones", "", "GeoJSON zones with their own multiplier and surcharge (overrides zones_file in the tariff)")
+	timeZone := flag.String("timezone", "", "IANA time zone for the night window, e.g. Asia/Tehran (overrides the tariff)")
+	columnAliases := flag.String("columns", "", "Extra header names (or NDJSON keys) for input columns, e.g. id_delivery=trip,lat=y")
+	timestampFormat := flag.String("timestamp-format", "auto", "Timestamp column format: auto, unix, unix_ms, unix_us, unix_ns or rfc3339")
+	parseWorkers := flag.Int("parse-workers", 1, "Goroutines parsing uncompressed input files in parallel chunks; 1 reads sequentially")
+	grouping := flag.String("grouping", "contiguous", "How rows form deliveries: contiguous (input grouped by id_delivery) or external (any order, sorted on disk)")
+	sortBuffer := flag.Int("sort-buffer", 1<<20, "Rows held in memory per sorted run with -grouping external")
+	tempDir := flag.String("temp-dir", "", "Directory for -grouping external sort runs (default: system temp dir)")
+	filters := flag.String("filters", strings.Join(ingestion.DefaultFilterNames, ","), "Comma-separated point filters, run in order: "+strings.Join(ingestion.FilterNames(), ", "))
+	maxSpeed := flag.Float64("max-speed", 100, "Speed in km/h above which a GPS point is treated as an outlier")
+	speedFilter := flag.String("speed-filter", "tracks", "Outlier algorithm: tracks (recovers from bad first points) or anchor (compare with last kept point)")
+	minAccuracy := flag.Float64("min-accuracy", 0, "Largest accepted GPS accuracy radius in meters, for the min-accuracy filter")
+	geofence := flag.String("geofence", "", "Bounding box minLat,minLng,maxLat,maxLng, for the geofence filter")
+	rejectsFile := flag.String("rejects", "", "Write rejected input rows (line, reason, raw record) to this CSV file")
+	maxPoints := flag.Int("max-points", 0, "Most rows held for one delivery; 0 for no limit")
+	maxDuration := flag.Duration("max-duration", 0, "Longest time one delivery may span, e.g. 12h; 0 for no limit")
+	overflow := flag.String("overflow", "split", "What to do with deliveries over -max-points or -max-duration: split (price in parts) or quarantine (drop and report)")
+	quarantineFile := flag.String("quarantine", "", "Write deliveries dropped by -overflow quarantine to this CSV file")
+	maxBufferedPoints := flag.Int("max-buffered-points", 0, "Most points read but not yet priced, bounding the pipeline's memory; 0 for no limit")
+	outputOrder := flag.String("order", "input", "Output order: input, id or completion; id fails if contiguous input is more than 1000 deliveries out of id order")
+	keepPrevious := flag.Bool("keep-previous", false, "Keep the existing output file if this run fails (default: remove it)")
+	breakdown := flag.Bool("breakdown", false, "Write per-delivery fare breakdown columns alongside fare_estimate")
+	checkpointEvery := flag.Duration("checkpoint", 0, "Save a checkpoint this often (e.g. 5m) so an interrupted run can be resumed; 0 disables")
+	resume := flag.Bool("resume", false, "Continue an interrupted run from its last checkpoint; give the same other arguments")
 	cpuProfile := flag.String("cpuprofile", "", "Write cpu profile to file")
 	memProfile := flag.String("memprofile", "", "Write memory profile to file")
 	flag.Parse()
 
 	//  input file is provided ?
-	if *inputFile == "" {
-		log.Fatal("Please provide an input file using the -input flag")
+	inputArgs = append(inputArgs, flag.Args()...)
+	if len(inputArgs) == 0 {
+		log.Println("Please provide an input file using the -input flag")
+		return exitInputError
+	}
+	inputFiles, err := ingestion.ExpandInputs(inputArgs)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+
+	// Load tariff
+	tariff := fare.DefaultTariff()
+	if *tariffFile != "" {
+		var err error
+		if tariff, err = fare.LoadTariff(*tariffFile); err != nil {
+			log.Printf("Could not load tariff: %v", err)
+			return exitInputError
+		}
+	}
+	if *timeZone != "" {
+		if err := tariff.SetTimeZone(*timeZone); err != nil {
+			log.Printf("Could not set time zone: %v", err)
+			return exitInputError
+		}
+	}
+	if *zonesFile != "" {
+		zones, err := fare.LoadZones(*zonesFile)
+		if err != nil {
+			log.Printf("Could not load zones: %v", err)
+			return exitInputError
+		}
+		tariff.SetZones(zones)
+	}
+
+	order, err := fare.ParseOrder(*outputOrder)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	format, err := ingestion.ParseInputFormat(*inputFormat)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	var aliases map[string][]string
+	if *columnAliases != "" {
+		if aliases, err = ingestion.ParseColumnAliases(*columnAliases); err != nil {
+			log.Println(err)
+			return exitInputError
+		}
+	}
+	tsFormat, err := utils.ParseTimestampFormat(*timestampFormat)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	groupingMode, err := ingestion.ParseGroupingMode(*grouping)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	speedFilterMode, err := ingestion.ParseSpeedFilterMode(*speedFilter)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	if *maxSpeed <= 0 {
+		log.Println("-max-speed must be positive")
+		return exitInputError
+	}
+	filterParams := ingestion.FilterParams{MaxSpeed: *maxSpeed, SpeedMode: speedFilterMode, MinAccuracy: *minAccuracy}
+	if *geofence != "" {
+		if filterParams.Geofence, err = ingestion.ParseBoundingBox(*geofence); err != nil {
+			log.Println(err)
+			return exitInputError
+		}
+	}
+	var filterNames []string
+	if *filters != "" {
+		filterNames = strings.Split(*filters, ",")
+	}
+	filterChain, err := ingestion.NewFilterChain(filterNames, filterParams)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	overflowPolicy, err := ingestion.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		log.Println(err)
+		return exitInputError
+	}
+	if *maxPoints < 0 || *maxDuration < 0 || *maxBufferedPoints < 0 {
+		log.Println("-max-points, -max-duration and -max-buffered-points must not be negative")
+		return exitInputError
+	}
+	if *quarantineFile != "" && overflowPolicy != ingestion.OverflowQuarantine {
+		log.Println("-quarantine needs -overflow quarantine")
+		return exitInputError
+	}
+	var budget *models.PointBudget
+	if *maxBufferedPoints > 0 {
+		budget = models.NewPointBudget(*maxBufferedPoints)
+	}
+
+	// Checkpoints pin the output to input positions, which needs deliveries
+	// written in input order, as they were read, from seekable inputs
+	checkpointing := *checkpointEvery > 0 || *resume
+	var checkpoint *output.Checkpoint
+	var inputStates []output.InputFile
+	checkpointFile := *outputFile + output.CheckpointSuffix
+	if checkpointing {
+		switch {
+		case *outputFile == stdoutOutput:
+			log.Println("-checkpoint and -resume need an output file")
+			return exitInputError
+		case order != fare.OrderInput:
+			log.Println("-checkpoint and -resume need -order input")
+			return exitInputError
+		case groupingMode != ingestion.GroupContiguous:
+			log.Println("-checkpoint and -resume need -grouping contiguous")
+			return exitInputError
+		}
+		if inputStates, err = statInputs(inputFiles); err != nil {
+			log.Println(err)
+			return exitInputError
+		}
+	}
+	if *resume {
+		if checkpoint, err = output.LoadCheckpoint(checkpointFile); err != nil {
+			log.Printf("Could not load checkpoint: %v", err)
+			return exitInputError
+		}
+		if err := checkResume(checkpoint, inputStates, runArgs()); err != nil {
+			log.Printf("Cannot resume: %v", err)
+			return exitInputError
+		}
+		log.Printf("Resuming after %d deliveries from the checkpoint of %v", checkpoint.Deliveries, checkpoint.Time.Format(time.RFC3339))
 	}
 
 	// CPU profiling
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
-			log.Fatal("Could not create CPU profile: ", err)
+			log.Println("Could not create CPU profile: ", err)
+			return exitOutputError
 		}
 		defer f.Close()
 		if err := pprof.StartCPUProfile(f); err != nil {
-			log.Fatal("Could not start CPU profile: ", err)
+			log.Println("Could not start CPU profile: ", err)
+			return exitOutputError
 		}
 		defer pprof.StopCPUProfile()
 	}
 
 	startTime := time.Now()
 
+	// SIGINT or SIGTERM stops the reader; the rest of the pipeline drains and
+	// the output is discarded. A second signal kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	// cancel stops the pipeline the same way when the output fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Rejected rows are counted per reason and optionally written out
+	var rejectsWriter *output.RejectsWriter
+	var reportsMu sync.Mutex // Checkpoints sync the report files from the writer goroutine
+	reportOpts := output.RejectsOptions{File: len(inputFiles) > 1}
+	if *rejectsFile != "" {
+		rejectsOpts := reportOpts
+		if checkpoint != nil {
+			rejectsWriter, err = output.ResumeRejectsWriter(*rejectsFile, rejectsOpts, checkpoint.Next.Rejects)
+		} else {
+			rejectsWriter, err = output.CreateRejectsWriterWithOptions(*rejectsFile, rejectsOpts)
+		}
+		if err != nil {
+			log.Printf("Could not create rejects file: %v", err)
+			return exitOutputError
+		}
+	}
+	rejectCounts := make(map[models.RejectReason]int)
+	fileRejectCounts := make(map[string]map[models.RejectReason]int)
+	fileRows := make(map[string]int64)
+	var rejectsErr error
+	onReject := func(row models.RejectedRow) {
+		rejectCounts[row.Reason]++
+		if fileRejectCounts[row.File] == nil {
+			fileRejectCounts[row.File] = make(map[models.RejectReason]int)
+		}
+		fileRejectCounts[row.File][row.Reason]++
+		if rejectsWriter != nil && rejectsErr == nil {
+			reportsMu.Lock()
+			rejectsErr = rejectsWriter.Write(row)
+			reportsMu.Unlock()
+		}
+	}
+
+	// Deliveries over the limits are counted and optionally reported
+	var quarantineWriter *output.QuarantineWriter
+	if *quarantineFile != "" {
+		if checkpoint != nil {
+			quarantineWriter, err = output.ResumeQuarantineWriter(*quarantineFile, reportOpts, checkpoint.Next.Quarantined)
+		} else {
+			quarantineWriter, err = output.CreateQuarantineWriter(*quarantineFile, reportOpts)
+		}
+		if err != nil {
+			log.Printf("Could not create quarantine file: %v", err)
+			return exitOutputError
+		}
+	}
+	var quarantined int
+	var quarantineErr error
+	onQuarantine := func(delivery models.QuarantinedDelivery) {
+		quarantined++
+		if quarantineWriter != nil && quarantineErr == nil {
+			reportsMu.Lock()
+			quarantineErr = quarantineWriter.Write(delivery)
+			reportsMu.Unlock()
+		}
+	}
+
 	// Read and filter input data
 	log.Println("Reading and filtering input data...")
-	pointsChan, errChan := ingestion.ReadAndFilterCSV(*inputFile)
+	var resumeFrom *models.InputPosition
+	if checkpoint != nil {
+		resumeFrom = &checkpoint.Next
+	}
+	pointsChan, errChan := ingestion.ReadAndFilterCSVFiles(ctx, inputFiles, ingestion.Options{
+		Resume:          resumeFrom,
+		OnReject:        onReject,
+		OnQuarantine:    onQuarantine,
+		OnSource:        func(summary ingestion.SourceSummary) { fileRows[summary.Name] = summary.Rows },
+		Format:          format,
+		ColumnAliases:   aliases,
+		TimestampFormat: tsFormat,
+		Grouping:        groupingMode,
+		SortBufferRows:  *sortBuffer,
+		TempDir:         *tempDir,
+		Filters:         filterChain,
+		ParseWorkers:    *parseWorkers,
+		Limits:          ingestion.DeliveryLimits{MaxPoints: *maxPoints, MaxDuration: *maxDuration, Overflow: overflowPolicy},
+		Budget:          budget,
+	})
 
 	// Calculate fares
 	log.Println("Calculating fares...")
-	estimatesChan := fare.CalculateFares(pointsChan)
+	estimatesChan, sortErrChan := fare.CalculateFaresOrdered(pointsChan, tariff, order)
+	// Input too far out of id order for -order id fails the run: stop reading
+	// as soon as that is known rather than pricing the rest
+	sortErrs := make(chan error, 1)
+	go func() {
+		err := <-sortErrChan
+		if err != nil {
+			cancel()
+		}
+		sortErrs <- err
+	}()
 
-	// Write results to CSV
+	// Write results to CSV. The file is only committed if reading/filtering
+	// got through the whole input; by the time estimatesChan is drained the
+	// reader has finished, so errChan already holds its error, if any.
+	// Standard output cannot be taken back, so there a failure only shows in
+	// the exit code.
 	log.Println("Writing results to CSV...")
-	if err := output.WriteCSV(*outputFile, estimatesChan); err != nil {
-		log.Fatalf("Error writing output data: %v", err)
+	var readErr error
+	outputOpts := output.Options{
+		Breakdown:    *breakdown,
+		KeepPrevious: *keepPrevious,
+		Cancel:       cancel,
+		BeforeCommit: func() error {
+			readErr = <-errChan
+			if sortErr := <-sortErrs; sortErr != nil {
+				readErr = sortErr // Reading was cancelled because of it
+			}
+			return readErr
+		},
+	}
+	if checkpointing {
+		args := runArgs()
+		outputOpts.Checkpoint = &output.CheckpointOptions{
+			Interval: *checkpointEvery,
+			Resume:   checkpoint,
+			Save: func(cp output.Checkpoint) error {
+				// Rejects and quarantined deliveries up to the checkpoint
+				// were written before its delivery was sent
+				reportsMu.Lock()
+				defer reportsMu.Unlock()
+				if rejectsWriter != nil {
+					if err := rejectsWriter.Sync(); err != nil {
+						return err
+					}
+				}
+				if quarantineWriter != nil {
+					if err := quarantineWriter.Sync(); err != nil {
+						return err
+					}
+				}
+				cp.Inputs, cp.Args = inputStates, args
+				return output.SaveCheckpoint(checkpointFile, cp)
+			},
+		}
+	}
+	toStdout := *outputFile == stdoutOutput
+	var writeErr error
+	if toStdout {
+		writeErr = output.Write(os.Stdout, estimatesChan, outputOpts)
+	} else {
+		writeErr = output.WriteCSVWithOptions(*outputFile, estimatesChan, outputOpts)
+	}
+	if writeErr != nil && checkpointing {
+		defer log.Printf("Partial output kept in %s; run again with -resume to continue from %s", *outputFile+output.PartialSuffix, checkpointFile)
+	}
+	if writeErr != nil {
+		if readErr == nil {
+			log.Printf("Error writing output data: %v", writeErr)
+			return exitOutputError
+		}
+		if errors.Is(readErr, context.Canceled) {
+			switch {
+			case toStdout:
+				log.Println("Interrupted; output is incomplete")
+			case checkpointing:
+				log.Println("Interrupted")
+			default:
+				log.Println("Interrupted; output discarded")
+			}
+			return exitInterrupted
+		}
+		log.Printf("Error during processing: %v", readErr)
+		if errors.Is(readErr, ingestion.ErrUnreadableInput) {
+			return exitInputError
+		}
+		return exitProcessingError
 	}
 
-	// Check for any errors from reading/filtering
-	for err := range errChan {
-		log.Printf("Error during processing: %v", err)
+	if rejectsWriter != nil {
+		if err := rejectsWriter.Close(); err != nil && rejectsErr == nil {
+			rejectsErr = err
+		}
+		if rejectsErr != nil {
+			log.Printf("Error writing rejects file: %v", rejectsErr)
+			return exitOutputError
+		}
+	}
+	if quarantineWriter != nil {
+		if err := quarantineWriter.Close(); err != nil && quarantineErr == nil {
+			quarantineErr = err
+		}
+		if quarantineErr != nil {
+			log.Printf("Error writing quarantine file: %v", quarantineErr)
+			return exitOutputError
+		}
+	}
+	if checkpointing {
+		if err := os.Remove(checkpointFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("Could not remove checkpoint: %v", err)
+		}
+	}
+	if len(inputFiles) > 1 {
+		for _, file := range inputFiles {
+			log.Printf("%s: read %d rows. %s", file, fileRows[file], rejectSummary(fileRejectCounts[file]))
+		}
+	}
+	log.Println(rejectSummary(rejectCounts))
+	if quarantined > 0 {
+		log.Printf("Quarantined %d deliveries over -max-points or -max-duration", quarantined)
 	}
 
 	duration := time.Since(startTime)
-	log.Printf("Fare estimation completed successfully in %v. Results written to %s\n", duration, *outputFile)
+	destination := *outputFile
+	if toStdout {
+		destination = "standard output"
+	}
+	log.Printf("Fare estimation completed successfully in %v. Results written to %s\n", duration, destination)
 
 	// Memory profiling
 	if *memProfile != "" {
 		f, err := os.Create(*memProfile)
 		if err != nil {
-			log.Fatal("Could not create memory profile: ", err)
+			log.Println("Could not create memory profile: ", err)
+			return exitOutputError
 		}
 		defer f.Close()
 		runtime.GC() // Get up-to-date statistics
 		if err := pprof.WriteHeapProfile(f); err != nil {
-			log.Fatal("Could not write memory profile: ", err)
+			log.Println("Could not write memory profile: ", err)
+			return exitOutputError
 		}
 	}
+
+	return exitOK
+}
+
+// inputList collects repeated -input flags
+type inputList []string
+
+func (l *inputList) String() string { return strings.Join(*l, ",") }
+
+func (l *inputList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// statInputs records the size and modification time of each input, so a
+// resumed run can tell they have not changed. Standard input cannot resume.
+func statInputs(files []string) ([]output.InputFile, error) {
+	states := make([]output.InputFile, len(files))
+	for i, file := range files {
+		if file == ingestion.StdinInput {
+			return nil, errors.New("-checkpoint and -resume cannot read standard input")
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		states[i] = output.InputFile{Name: file, Size: info.Size(), ModTime: info.ModTime().UTC()}
+	}
+	return states, nil
+}
+
+// runArgs returns the command-line arguments, without -resume, which a
+// resumed run must repeat
+func runArgs() []string {
+	var args []string
+	for _, arg := range os.Args[1:] {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "resume" {
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// checkResume makes sure a checkpoint was saved by a run over the same
+// inputs with the same arguments
+func checkResume(checkpoint *output.Checkpoint, inputs []output.InputFile, args []string) error {
+	if !slices.Equal(checkpoint.Args, args) {
+		return fmt.Errorf("the checkpoint was saved with other arguments: %s", strings.Join(checkpoint.Args, " "))
+	}
+	if len(checkpoint.Inputs) != len(inputs) {
+		return fmt.Errorf("the checkpoint was saved with %d inputs, not %d", len(checkpoint.Inputs), len(inputs))
+	}
+	for i, input := range inputs {
+		saved := checkpoint.Inputs[i]
+		if saved.Name != input.Name || saved.Size != input.Size || !saved.ModTime.Equal(input.ModTime) {
+			return fmt.Errorf("%s has changed since the checkpoint", input.Name)
+		}
+	}
+	return nil
+}
+
+// rejectSummary formats the number of rejected rows per reason
+func rejectSummary(counts map[models.RejectReason]int) string {
+	total := 0
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		total += count
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+	}
+	if total == 0 {
+		return "No input rows were rejected"
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("Rejected %d input rows (%s)", total, strings.Join(reasons, ", "))
 }
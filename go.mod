@@ -1,3 +1,8 @@
 module SBCFAA
 
 go 1.22.5
+
+require (
+	github.com/klauspost/compress v1.17.11
+	gopkg.in/yaml.v3 v3.0.1
+)
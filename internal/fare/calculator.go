@@ -1,7 +1,7 @@
 package fare
 
 import (
-	"SBCFAA/pkg/utils"
+	"log"
 	"math"
 	"sync"
 	"time"
@@ -9,6 +9,7 @@ import (
 	"SBCFAA/internal/models"
 )
 
+// Default tariff values, used when no tariff file is supplied
 const (
 	FlagCharge           = 1.30
 	MinimumFare          = 3.47
@@ -18,11 +19,38 @@ const (
 	MovingSpeedThreshold = 10.0  // km/hour
 	NightStartHour       = 0
 	NightEndHour         = 5
-	workerPoolSize       = 5
 )
 
-func CalculateFares(deliveries <-chan []models.DeliveryPoint) <-chan models.FareEstimate {
+const workerPoolSize = 5
+
+// CalculateFares prices every delivery with the given tariff and emits the
+// estimates as workers finish them. A nil tariff falls back to DefaultTariff.
+// The parts of a delivery split by ingestion come out as one estimate.
+func CalculateFares(deliveries <-chan models.Delivery, tariff *Tariff) <-chan models.FareEstimate {
+	if tariff == nil {
+		tariff = DefaultTariff()
+	}
+	return joinParts(tariff.priceDeliveries(deliveries))
+}
+
+// priceDeliveries prices whole deliveries on the worker pool and the parts of
+// split ones on a single meter goroutine, with one estimate per delivery or part
+func (t *Tariff) priceDeliveries(deliveries <-chan models.Delivery) <-chan models.FareEstimate {
 	estimatesChan := make(chan models.FareEstimate, 100)
+	wholeChan := make(chan models.Delivery)
+	partsChan := make(chan models.Delivery)
+
+	go func() {
+		defer close(wholeChan)
+		defer close(partsChan)
+		for delivery := range deliveries {
+			if delivery.Part == 0 {
+				wholeChan <- delivery
+			} else {
+				partsChan <- delivery
+			}
+		}
+	}()
 
 	go func() {
 		defer close(estimatesChan)
@@ -32,12 +60,19 @@ func CalculateFares(deliveries <-chan []models.DeliveryPoint) <-chan models.Fare
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				for delivery := range deliveries {
-					estimate := calculateFareForDelivery(delivery)
+				for delivery := range wholeChan {
+					estimate := t.calculateFareForDelivery(delivery.Points)
+					delivery.Budget.Release(len(delivery.Points))
+					estimate.Seq, estimate.Next = delivery.Seq, delivery.Next
 					estimatesChan <- estimate
 				}
 			}()
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.meterParts(partsChan, estimatesChan)
+		}()
 
 		wg.Wait()
 	}()
@@ -45,47 +80,171 @@ func CalculateFares(deliveries <-chan []models.DeliveryPoint) <-chan models.Fare
 	return estimatesChan
 }
 
-func calculateFareForDelivery(delivery []models.DeliveryPoint) models.FareEstimate {
-	if len(delivery) == 0 {
-		return models.FareEstimate{}
+// meterParts feeds the parts of each split delivery, which ingestion emits in
+// order, into one Meter, so only its running totals are held from one part to
+// the next and the fare is the same as if the delivery had not been split.
+// The last part's estimate carries the fare; the others only account for
+// their Seq.
+func (t *Tariff) meterParts(parts <-chan models.Delivery, estimates chan<- models.FareEstimate) {
+	meters := make(map[int64]*Meter) // by the Seq of the first part
+	for part := range parts {
+		first := part.Seq - int64(part.Part-1)
+		meter, ok := meters[first]
+		if !ok {
+			meter = NewMeter(t)
+			meters[first] = meter
+		}
+		points := part.Points
+		if part.Part > 1 {
+			points = points[1:] // The previous part's last point, already added
+		}
+		for _, point := range points {
+			meter.add(point)
+		}
+		part.Budget.Release(len(part.Points))
+
+		estimate := models.FareEstimate{DeliveryID: part.Points[0].ID}
+		if !part.More {
+			estimate = meter.Finalize()
+			delete(meters, first)
+		}
+		estimate.Seq, estimate.Next = part.Seq, part.Next
+		estimate.Part, estimate.More = part.Part, part.More
+		estimates <- estimate
 	}
 
-	totalFare := FlagCharge
-	for i := 1; i < len(delivery); i++ {
-		prevPoint := delivery[i-1]
-		currentPoint := delivery[i]
+	if len(meters) > 0 { // Only possible if a last part never came
+		log.Printf("Warning: %d split deliveries were missing parts", len(meters))
+	}
+}
 
-		distance := utils.HaversineDistance(prevPoint.Latitude, prevPoint.Longitude, currentPoint.Latitude, currentPoint.Longitude)
-		duration := currentPoint.Timestamp.Sub(prevPoint.Timestamp)
-		speed := utils.CalculateSpeed(prevPoint, currentPoint)
+func (t *Tariff) calculateFareForDelivery(delivery []models.DeliveryPoint) models.FareEstimate {
+	return t.meter(delivery).Finalize()
+}
 
-		fare := calculateSegmentFare(distance, duration, speed, currentPoint.Timestamp)
-		totalFare += fare
+// meter runs a delivery's points, which ingestion has put in timestamp
+// order, through a Meter
+func (t *Tariff) meter(delivery []models.DeliveryPoint) *Meter {
+	meter := NewMeter(t)
+	for _, point := range delivery {
+		meter.add(point)
 	}
+	return meter
+}
 
-	if totalFare < MinimumFare {
-		totalFare = MinimumFare
+// finish turns the measured segments of a delivery into its fare, adding the
+// flag charge and applying the minimum fare
+func (t *Tariff) finish(id int64, breakdown models.FareBreakdown) models.FareEstimate {
+	breakdown.FlagCharge = t.FlagCharge
+	totalFare := breakdown.FlagCharge + breakdown.MovingDayCost + breakdown.MovingNightCost + breakdown.IdleCost + breakdown.ZoneSurcharge
+	if totalFare < t.MinimumFare {
+		totalFare = t.MinimumFare
+		breakdown.MinimumFareApplied = true
 	}
 
 	return models.FareEstimate{
-		DeliveryID: delivery[0].ID,
+		DeliveryID: id,
 		Fare:       math.Round(totalFare*100) / 100, // Round to 2decimal
+		Breakdown:  breakdown,
 	}
 }
 
-func calculateSegmentFare(distance float64, duration time.Duration, speed float64, timestamp time.Time) float64 {
-	if speed <= MovingSpeedThreshold { // Idle state
-		return IdleRate * duration.Hours()
+// joinParts passes whole deliveries' estimates through and, for each split
+// delivery, the estimate of its last part under the Seq of the first. The
+// other parts' estimates are dropped.
+func joinParts(estimates <-chan models.FareEstimate) <-chan models.FareEstimate {
+	joinedChan := make(chan models.FareEstimate, 100)
+
+	go func() {
+		defer close(joinedChan)
+
+		for estimate := range estimates {
+			if estimate.More {
+				continue
+			}
+			if estimate.Part > 0 {
+				estimate.Seq -= int64(estimate.Part - 1)
+				estimate.Part = 0
+			}
+			joinedChan <- estimate
+		}
+	}()
+
+	return joinedChan
+}
+
+// calculateSegmentFare prices the segment between start and end
+func (t *Tariff) calculateSegmentFare(distance, speed float64, start, end time.Time) float64 {
+	var segment models.FareBreakdown
+	t.addSegment(&segment, distance, speed, start, end, 1)
+	return segment.MovingDayCost + segment.MovingNightCost + segment.IdleCost
+}
+
+// addSegment adds the segment between start and end to breakdown, with its
+// costs scaled by multiplier. Moving segments are split between the day and
+// night rates in proportion to the time spent in each window.
+func (t *Tariff) addSegment(breakdown *models.FareBreakdown, distance, speed float64, start, end time.Time, multiplier float64) {
+	duration := end.Sub(start)
+	if speed <= t.MovingSpeedThreshold { // Idle state
+		breakdown.IdleHours += duration.Hours()
+		breakdown.IdleCost += t.IdleRate * duration.Hours() * multiplier
+		return
+	}
+
+	var nightShare float64 // Moving state
+	if duration > 0 {
+		nightShare = t.nightDuration(start, end).Seconds() / duration.Seconds()
+	} else if t.isNightTime(end) { // Nothing to prorate, use the rate at the end point
+		nightShare = 1
 	}
 
-	rate := MovingRateDay // Moving state
-	if isNightTime(timestamp) {
-		rate = MovingRateNight
+	dayKm, nightKm := distance*(1-nightShare), distance*nightShare
+	breakdown.MovingDayKm += dayKm
+	breakdown.MovingNightKm += nightKm
+	breakdown.MovingDayCost += t.MovingRateDay * dayKm * multiplier
+	breakdown.MovingNightCost += t.MovingRateNight * nightKm * multiplier
+}
+
+// nightDuration returns how much of [start, end) falls in the night window
+func (t *Tariff) nightDuration(start, end time.Time) time.Duration {
+	var night time.Duration
+	for cursor := start; cursor.Before(end); {
+		next := t.nextBoundary(cursor)
+		if next.After(end) {
+			next = end
+		}
+		if t.isNightTime(cursor) {
+			night += next.Sub(cursor)
+		}
+		cursor = next
 	}
-	return rate * distance
+	return night
 }
 
-func isNightTime(t time.Time) bool {
-	hour := t.Hour()
-	return hour >= NightStartHour && hour < NightEndHour
+// nextBoundary returns the first night start or night end strictly after ts
+func (t *Tariff) nextBoundary(ts time.Time) time.Time {
+	location := t.Location()
+	year, month, day := ts.In(location).Date()
+
+	var next time.Time
+	for offset := 0; offset <= 2; offset++ { // Two days ahead covers DST shifts
+		for _, hour := range []int{t.NightStartHour, t.NightEndHour} {
+			boundary := time.Date(year, month, day+offset, hour, 0, 0, 0, location)
+			if boundary.After(ts) && (next.IsZero() || boundary.Before(next)) {
+				next = boundary
+			}
+		}
+	}
+	return next
+}
+
+// isNightTime reports whether ts falls in the night window, which may wrap
+// past midnight (e.g. 22-5). The hour is read in the tariff's zone, never the
+// host's.
+func (t *Tariff) isNightTime(ts time.Time) bool {
+	hour := ts.In(t.Location()).Hour()
+	if t.NightStartHour < t.NightEndHour {
+		return hour >= t.NightStartHour && hour < t.NightEndHour
+	}
+	return hour >= t.NightStartHour || hour < t.NightEndHour
 }
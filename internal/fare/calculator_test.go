@@ -2,6 +2,7 @@ package fare
 
 import (
 	"SBCFAA/internal/models"
+	"math"
 	"reflect"
 	"sort"
 	"testing"
@@ -87,16 +88,18 @@ func TestCalculateFares(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Create input channel
-			deliveriesChan := make(chan []models.DeliveryPoint, len(tc.input))
-			for _, delivery := range tc.input {
-				deliveriesChan <- delivery
+			deliveriesChan := make(chan models.Delivery, len(tc.input))
+			for i, delivery := range tc.input {
+				deliveriesChan <- models.Delivery{Seq: int64(i), Points: delivery}
 			}
 			close(deliveriesChan)
 
-			resultChan := CalculateFares(deliveriesChan) // Call CalculateFares
+			resultChan := CalculateFares(deliveriesChan, DefaultTariff()) // Call CalculateFares
 			// Collect results
 			var results []models.FareEstimate
 			for estimate := range resultChan {
+				estimate.Breakdown = models.FareBreakdown{} // Covered by TestCalculateFareBreakdown
+				estimate.Seq = 0                            // Covered by TestCalculateFaresOrdered
 				results = append(results, estimate)
 			}
 
@@ -115,59 +118,200 @@ func TestCalculateFares(t *testing.T) {
 	}
 }
 
+func TestCalculateFareBreakdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery []models.DeliveryPoint
+		expected models.FareBreakdown
+	}{
+		{
+			name: "Idle then moving across night end",
+			delivery: []models.DeliveryPoint{
+				{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 4, 0, 0, 0, time.UTC)},
+				{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 4, 30, 0, 0, time.UTC)},
+				{ID: 1, Latitude: 40.8028, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 5, 30, 0, 0, time.UTC)},
+			},
+			expected: models.FareBreakdown{
+				FlagCharge:      FlagCharge,
+				MovingDayKm:     5.003708,
+				MovingNightKm:   5.003708,
+				IdleHours:       0.5,
+				MovingDayCost:   5.003708 * MovingRateDay,
+				MovingNightCost: 5.003708 * MovingRateNight,
+				IdleCost:        IdleRate * 0.5,
+				Points:          3,
+				Segments:        2,
+			},
+		},
+		{
+			name: "Minimum fare applied",
+			delivery: []models.DeliveryPoint{
+				{ID: 2, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+			},
+			expected: models.FareBreakdown{
+				FlagCharge:         FlagCharge,
+				MinimumFareApplied: true,
+				Points:             1,
+				Segments:           0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkBreakdown(t, DefaultTariff().calculateFareForDelivery(tt.delivery).Breakdown, tt.expected)
+		})
+	}
+}
+
+// checkBreakdown compares the amounts of a breakdown to within 1e-4 and its
+// counts exactly
+func checkBreakdown(t *testing.T, result, expected models.FareBreakdown) {
+	t.Helper()
+	const tolerance = 1e-4
+	floats := []struct {
+		name          string
+		got, expected float64
+	}{
+		{"FlagCharge", result.FlagCharge, expected.FlagCharge},
+		{"MovingDayKm", result.MovingDayKm, expected.MovingDayKm},
+		{"MovingNightKm", result.MovingNightKm, expected.MovingNightKm},
+		{"IdleHours", result.IdleHours, expected.IdleHours},
+		{"MovingDayCost", result.MovingDayCost, expected.MovingDayCost},
+		{"MovingNightCost", result.MovingNightCost, expected.MovingNightCost},
+		{"IdleCost", result.IdleCost, expected.IdleCost},
+	}
+	for _, f := range floats {
+		if math.Abs(f.got-f.expected) > tolerance {
+			t.Errorf("%s = %v, want %v", f.name, f.got, f.expected)
+		}
+	}
+	if result.MinimumFareApplied != expected.MinimumFareApplied {
+		t.Errorf("MinimumFareApplied = %v, want %v", result.MinimumFareApplied, expected.MinimumFareApplied)
+	}
+	if result.Points != expected.Points || result.Segments != expected.Segments {
+		t.Errorf("Points/Segments = %d/%d, want %d/%d", result.Points, result.Segments, expected.Points, expected.Segments)
+	}
+}
+
 func TestCalculateSegmentFare(t *testing.T) {
 	tests := []struct {
-		name      string
-		distance  float64
-		duration  time.Duration
-		speed     float64
-		timestamp time.Time
-		expected  float64
+		name     string
+		distance float64
+		speed    float64
+		start    time.Time
+		end      time.Time
+		expected float64
 	}{
 		{
-			name:      "Idle state during day",
-			distance:  0.5,
-			duration:  30 * time.Minute,
-			speed:     5.0,
-			timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
-			expected:  IdleRate * 0.5, // 30 minutes = 0.5 hours
+			name:     "Idle state during day",
+			distance: 0.5,
+			speed:    5.0,
+			start:    time.Date(2023, 1, 1, 11, 30, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: IdleRate * 0.5, // 30 minutes = 0.5 hours
 		},
 		{
-			name:      "Moving state during day",
-			distance:  10.0,
-			duration:  30 * time.Minute,
-			speed:     20.0,
-			timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
-			expected:  MovingRateDay * 10.0,
+			name:     "Moving state during day",
+			distance: 10.0,
+			speed:    20.0,
+			start:    time.Date(2023, 1, 1, 11, 30, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: MovingRateDay * 10.0,
 		},
 		{
-			name:      "Moving state during night",
-			distance:  10.0,
-			duration:  30 * time.Minute,
-			speed:     20.0,
-			timestamp: time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC),
-			expected:  MovingRateNight * 10.0,
+			name:     "Moving state during night",
+			distance: 10.0,
+			speed:    20.0,
+			start:    time.Date(2023, 1, 1, 1, 30, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC),
+			expected: MovingRateNight * 10.0,
 		},
 		{
-			name:      "Exactly at speed threshold",
-			distance:  5.0,
-			duration:  30 * time.Minute,
-			speed:     MovingSpeedThreshold,
-			timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
-			expected:  IdleRate * 0.5, // 30 minutes = 0.5 hours
+			name:     "Exactly at speed threshold",
+			distance: 5.0,
+			speed:    MovingSpeedThreshold,
+			start:    time.Date(2023, 1, 1, 11, 30, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: IdleRate * 0.5, // 30 minutes = 0.5 hours
+		},
+		{
+			name:     "Straddles night end",
+			distance: 5.0,
+			speed:    60.0,
+			start:    time.Date(2023, 1, 1, 4, 58, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 5, 3, 0, 0, time.UTC),
+			expected: 5.0 * (MovingRateNight*2/5 + MovingRateDay*3/5), // 2 min night, 3 min day
+		},
+		{
+			name:     "Straddles midnight",
+			distance: 20.0,
+			speed:    60.0,
+			start:    time.Date(2023, 1, 1, 23, 50, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 2, 0, 10, 0, 0, time.UTC),
+			expected: 20.0 * (MovingRateDay*0.5 + MovingRateNight*0.5),
+		},
+		{
+			name:     "Spans a whole night",
+			distance: 240.0,
+			speed:    20.0,
+			start:    time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC),
+			expected: 240.0 * (MovingRateDay*19/24 + MovingRateNight*5/24),
+		},
+		{
+			name:     "Zero duration at night",
+			distance: 1.0,
+			speed:    math.Inf(1),
+			start:    time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC),
+			end:      time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC),
+			expected: MovingRateNight * 1.0,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := calculateSegmentFare(tt.distance, tt.duration, tt.speed, tt.timestamp)
-			if result != tt.expected {
+			result := DefaultTariff().calculateSegmentFare(tt.distance, tt.speed, tt.start, tt.end)
+			if math.Abs(result-tt.expected) > 1e-9 {
 				t.Errorf("calculateSegmentFare() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
 
+func TestNightDuration(t *testing.T) {
+	tehran := DefaultTariff()
+	if err := tehran.SetTimeZone("Asia/Tehran"); err != nil {
+		t.Fatalf("SetTimeZone failed: %v", err)
+	}
+	wrapping := DefaultTariff()
+	wrapping.NightStartHour = 22
+	wrapping.NightEndHour = 6
+
+	tests := []struct {
+		name     string
+		tariff   *Tariff
+		start    time.Time
+		end      time.Time
+		expected time.Duration
+	}{
+		{"Day only", DefaultTariff(), time.Date(2023, 1, 1, 8, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC), 0},
+		{"Night only", DefaultTariff(), time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC), time.Hour},
+		{"Two nights", DefaultTariff(), time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC), time.Date(2023, 1, 2, 1, 0, 0, 0, time.UTC), 3 * time.Hour},
+		{"Wrapping window", wrapping, time.Date(2023, 1, 1, 21, 0, 0, 0, time.UTC), time.Date(2023, 1, 2, 7, 0, 0, 0, time.UTC), 8 * time.Hour},
+		{"Tariff zone", tehran, time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 21, 0, 0, 0, time.UTC), 30 * time.Minute},
+		{"Empty interval", DefaultTariff(), time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.tariff.nightDuration(tt.start, tt.end); result != tt.expected {
+				t.Errorf("nightDuration() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestIsNightTime(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -183,10 +327,128 @@ func TestIsNightTime(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isNightTime(tt.time)
+			result := DefaultTariff().isNightTime(tt.time)
 			if result != tt.expected {
 				t.Errorf("isNightTime() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
+
+// TestCalculateFaresIgnoresHostZone prices the same night-time delivery with
+// the process-wide local zone set to several values, which is what the TZ
+// environment variable controls.
+func TestCalculateFaresIgnoresHostZone(t *testing.T) {
+	originalLocal := time.Local
+	defer func() { time.Local = originalLocal }()
+
+	// 02:00-02:10 UTC, moving ~9 km: night rate in UTC
+	start := time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC).Unix()
+	delivery := []models.DeliveryPoint{
+		{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Unix(start, 0)},
+		{ID: 1, Latitude: 40.7938, Longitude: -74.0060, Timestamp: time.Unix(start+600, 0)},
+	}
+
+	var fares []float64
+	for _, zone := range []string{"UTC", "Asia/Tehran", "America/New_York", "Pacific/Kiritimati"} {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", zone, err)
+		}
+		time.Local = location
+
+		deliveriesChan := make(chan models.Delivery, 1)
+		deliveriesChan <- models.Delivery{Points: delivery}
+		close(deliveriesChan)
+		for estimate := range CalculateFares(deliveriesChan, DefaultTariff()) {
+			fares = append(fares, estimate.Fare)
+		}
+	}
+
+	for i := 1; i < len(fares); i++ {
+		if fares[i] != fares[0] {
+			t.Errorf("Fare depends on host zone: got %v", fares)
+			break
+		}
+	}
+	if expected := DefaultTariff().calculateFareForDelivery(delivery).Fare; fares[0] != expected {
+		t.Errorf("Fare = %v, want %v", fares[0], expected)
+	}
+}
+
+func TestCalculateFaresJoinsZones(t *testing.T) {
+	tariff := DefaultTariff()
+	tariff.SetZones(mustParseZones(t, featureCollection(
+		boxFeature(`"name": "North", "multiplier": 2, "surcharge": 5`, 42.0, -74.01, 42.2, -74.0),
+		boxFeature(`"name": "South", "surcharge": 1.5`, 41.0, -74.01, 41.2, -74.0),
+	)))
+	run := func(deliveries []models.Delivery) models.FareEstimate {
+		deliveriesChan := make(chan models.Delivery, len(deliveries))
+		for _, delivery := range deliveries {
+			deliveriesChan <- delivery
+		}
+		close(deliveriesChan)
+		var results []models.FareEstimate
+		estimatesChan, _ := CalculateFaresOrdered(deliveriesChan, tariff, OrderInput)
+		for estimate := range estimatesChan {
+			results = append(results, estimate)
+		}
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 estimate, got %d", len(results))
+		}
+		return results[0]
+	}
+
+	// South lies in the first part; North straddles the second and third
+	points := makeDeliveries([]int64{1}, 1)[0].Points
+	whole := run([]models.Delivery{{Points: points}})
+	split := run([]models.Delivery{
+		{Seq: 0, Points: points[:700], Part: 1, More: true},
+		{Seq: 1, Points: points[699:1500], Part: 2, More: true},
+		{Seq: 2, Points: points[1499:], Part: 3},
+	})
+
+	if !reflect.DeepEqual(whole.Breakdown.Zones, []string{"South", "North"}) || whole.Breakdown.ZoneSurcharge != 6.5 {
+		t.Errorf("Expected South then North for 6.50, got %v for %.2f", whole.Breakdown.Zones, whole.Breakdown.ZoneSurcharge)
+	}
+	if split.Fare != whole.Fare || !reflect.DeepEqual(split.Breakdown, whole.Breakdown) {
+		t.Errorf("Expected the joined parts to match the whole delivery, got %+v, want %+v", split, whole)
+	}
+}
+
+func TestCalculateFaresJoinsParts(t *testing.T) {
+	whole := makeDeliveries([]int64{1, 2, 3}, 1)
+	points := whole[1].Points
+	last := models.InputPosition{Offset: 1234, Line: 56}
+
+	// Delivery 2 in three parts, each starting with the previous part's last point
+	split := []models.Delivery{
+		whole[0],
+		{Seq: 1, Points: points[:700], Part: 1, More: true},
+		{Seq: 2, Points: points[699:1400], Part: 2, More: true},
+		{Seq: 3, Points: points[1399:], Part: 3, Next: last},
+		{Seq: 4, Points: whole[2].Points},
+	}
+	expected := runOrdered(t, whole, OrderInput)
+
+	for _, order := range []Order{OrderCompletion, OrderInput, OrderDeliveryID} {
+		results := runOrdered(t, split, order)
+		if len(results) != len(expected) {
+			t.Fatalf("order %v: expected %d estimates, got %d", order, len(expected), len(results))
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].Seq < results[j].Seq })
+		for i, estimate := range results {
+			e := expected[i]
+			if estimate.DeliveryID != e.DeliveryID || estimate.Fare != e.Fare {
+				t.Errorf("order %v: estimate %d is id %d fare %.2f, want id %d fare %.2f", order, i, estimate.DeliveryID, estimate.Fare, e.DeliveryID, e.Fare)
+			}
+			// One meter runs through every part, so the totals match exactly
+			if !reflect.DeepEqual(estimate.Breakdown, e.Breakdown) {
+				t.Errorf("order %v: estimate %d breakdown %+v, want %+v", order, i, estimate.Breakdown, e.Breakdown)
+			}
+		}
+		if results[1].Seq != 1 || results[1].Next != last || results[1].Part != 0 {
+			t.Errorf("order %v: expected the joined estimate to take the first part's Seq and the last part's Next, got %+v", order, results[1])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package fare
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+// Errors returned by Meter.AddPoint
+var (
+	ErrMeterFinalized  = errors.New("meter is finalized")
+	ErrPointOutOfOrder = errors.New("point is older than the previous one")
+)
+
+// Meter prices one delivery a point at a time, keeping running totals, so
+// neither a live trip nor a huge recorded one has to be held in memory.
+// Feeding a delivery's points to a Meter gives the same estimate as pricing
+// them all at once. A Meter is not safe for concurrent use.
+type Meter struct {
+	tariff    *Tariff
+	breakdown models.FareBreakdown // segments so far, without the flag charge
+	idle      time.Duration
+	last      models.DeliveryPoint
+	finalized bool
+}
+
+// NewMeter returns a meter for the given tariff; nil means DefaultTariff
+func NewMeter(tariff *Tariff) *Meter {
+	if tariff == nil {
+		tariff = DefaultTariff()
+	}
+	return &Meter{tariff: tariff}
+}
+
+// AddPoint prices the segment from the previous point to point. Points must
+// belong to one delivery and come in timestamp order; equal timestamps are
+// allowed.
+func (m *Meter) AddPoint(point models.DeliveryPoint) error {
+	if m.finalized {
+		return ErrMeterFinalized
+	}
+	if m.breakdown.Points > 0 {
+		if point.ID != m.last.ID {
+			return fmt.Errorf("point of delivery %d added to the meter of delivery %d", point.ID, m.last.ID)
+		}
+		if point.Timestamp.Before(m.last.Timestamp) {
+			return ErrPointOutOfOrder
+		}
+	}
+	m.add(point)
+	return nil
+}
+
+// add is AddPoint without the checks, for points ingestion has already
+// grouped and sorted
+func (m *Meter) add(point models.DeliveryPoint) {
+	if m.breakdown.Points > 0 {
+		distance := utils.HaversineDistance(m.last.Latitude, m.last.Longitude, point.Latitude, point.Longitude)
+		speed := utils.CalculateSpeed(m.last, point)
+		if speed <= m.tariff.MovingSpeedThreshold {
+			m.idle += point.Timestamp.Sub(m.last.Timestamp)
+		}
+		multiplier := 1.0
+		// A segment is priced in the zone of its midpoint
+		if zone := m.tariff.zones.Find((m.last.Latitude+point.Latitude)/2, (m.last.Longitude+point.Longitude)/2); zone != nil {
+			multiplier = zone.Multiplier
+			m.enter(zone)
+		}
+		m.tariff.addSegment(&m.breakdown, distance, speed, m.last.Timestamp, point.Timestamp, multiplier)
+		m.breakdown.Segments++
+	}
+	m.breakdown.Points++
+	m.last = point
+}
+
+// enter records that the delivery passed through zone, charging its
+// surcharge the first time
+func (m *Meter) enter(zone *Zone) {
+	if !slices.Contains(m.breakdown.Zones, zone.Name) {
+		m.breakdown.Zones = append(m.breakdown.Zones, zone.Name)
+		m.breakdown.ZoneSurcharge += zone.Surcharge
+	}
+}
+
+// Points returns how many points have been added
+func (m *Meter) Points() int { return m.breakdown.Points }
+
+// Distance returns the kilometres travelled while moving
+func (m *Meter) Distance() float64 {
+	return m.breakdown.MovingDayKm + m.breakdown.MovingNightKm
+}
+
+// IdleTime returns the time spent below the moving speed threshold
+func (m *Meter) IdleTime() time.Duration { return m.idle }
+
+// Fare returns what the delivery would cost if it ended at the last point,
+// with the flag charge and minimum fare applied; 0 before the first point
+func (m *Meter) Fare() float64 { return m.estimate().Fare }
+
+// Breakdown itemises Fare
+func (m *Meter) Breakdown() models.FareBreakdown { return m.estimate().Breakdown }
+
+// Finalize ends the delivery and returns its estimate. Further points are
+// refused; the running totals stay readable.
+func (m *Meter) Finalize() models.FareEstimate {
+	m.finalized = true
+	return m.estimate()
+}
+
+func (m *Meter) estimate() models.FareEstimate {
+	if m.breakdown.Points == 0 {
+		return models.FareEstimate{}
+	}
+	return m.tariff.finish(m.last.ID, m.breakdown)
+}
@@ -0,0 +1,150 @@
+package fare
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// meterRoute idles for half an hour, then drives 10 km across the end of the night window
+var meterRoute = []models.DeliveryPoint{
+	{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 4, 0, 0, 0, time.UTC)},
+	{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 4, 30, 0, 0, time.UTC)},
+	{ID: 1, Latitude: 40.7578, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 5, 0, 0, 0, time.UTC)},
+	{ID: 1, Latitude: 40.8028, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 5, 30, 0, 0, time.UTC)},
+}
+
+func TestMeterRunningFare(t *testing.T) {
+	// After each point of meterRoute: what the delivery would cost if it ended there
+	tests := []struct {
+		fare     float64
+		expected models.FareBreakdown
+	}{
+		{3.47, models.FareBreakdown{FlagCharge: FlagCharge, MinimumFareApplied: true, Points: 1}},
+		{7.25, models.FareBreakdown{FlagCharge: FlagCharge, IdleHours: 0.5, IdleCost: IdleRate * 0.5, Points: 2, Segments: 1}},
+		{13.75, models.FareBreakdown{FlagCharge: FlagCharge, MovingNightKm: 5.003708, IdleHours: 0.5,
+			MovingNightCost: 5.003708 * MovingRateNight, IdleCost: IdleRate * 0.5, Points: 3, Segments: 2}},
+		{17.46, models.FareBreakdown{FlagCharge: FlagCharge, MovingDayKm: 5.003708, MovingNightKm: 5.003708, IdleHours: 0.5,
+			MovingDayCost: 5.003708 * MovingRateDay, MovingNightCost: 5.003708 * MovingRateNight, IdleCost: IdleRate * 0.5, Points: 4, Segments: 3}},
+	}
+
+	meter := NewMeter(DefaultTariff())
+	for i, point := range meterRoute {
+		t.Run(fmt.Sprintf("After point %d", i), func(t *testing.T) {
+			if err := meter.AddPoint(point); err != nil {
+				t.Fatalf("AddPoint failed: %v", err)
+			}
+			if meter.Fare() != tests[i].fare {
+				t.Errorf("Fare = %.2f, want %.2f", meter.Fare(), tests[i].fare)
+			}
+			checkBreakdown(t, meter.Breakdown(), tests[i].expected)
+
+			// The same as pricing the points so far all at once
+			batch := DefaultTariff().calculateFareForDelivery(meterRoute[:i+1])
+			if meter.Fare() != batch.Fare || !reflect.DeepEqual(meter.Breakdown(), batch.Breakdown) {
+				t.Errorf("Meter reads %.2f %+v, batch pricing gives %.2f %+v", meter.Fare(), meter.Breakdown(), batch.Fare, batch.Breakdown)
+			}
+		})
+	}
+
+	result := meter.Finalize()
+	last := tests[len(tests)-1]
+	if result.Fare != last.fare || result.DeliveryID != 1 {
+		t.Errorf("Finalize: delivery %d fare %.2f, want delivery 1 fare %.2f", result.DeliveryID, result.Fare, last.fare)
+	}
+	checkBreakdown(t, result.Breakdown, last.expected)
+}
+
+func TestMeterRunningTotals(t *testing.T) {
+	meter := NewMeter(nil)
+	if meter.Fare() != 0 || meter.Points() != 0 {
+		t.Errorf("Expected an empty meter to read 0, got fare %.2f after %d points", meter.Fare(), meter.Points())
+	}
+
+	for _, point := range meterRoute {
+		if err := meter.AddPoint(point); err != nil {
+			t.Fatalf("AddPoint failed: %v", err)
+		}
+	}
+	if meter.Points() != 4 {
+		t.Errorf("Points = %d, want 4", meter.Points())
+	}
+	if math.Abs(meter.Distance()-10.0075) > 1e-3 {
+		t.Errorf("Distance = %v km, want 10.0075", meter.Distance())
+	}
+	if meter.IdleTime() != 30*time.Minute {
+		t.Errorf("IdleTime = %v, want 30m", meter.IdleTime())
+	}
+}
+
+func TestMeterZones(t *testing.T) {
+	// Drive north into Centre, then back south inside it
+	route := append(append([]models.DeliveryPoint{}, meterRoute...),
+		models.DeliveryPoint{ID: 1, Latitude: 40.7578, Longitude: -74.0060, Timestamp: time.Date(2023, 1, 1, 6, 0, 0, 0, time.UTC)})
+
+	plain := NewMeter(nil)
+	tariff := DefaultTariff()
+	tariff.SetZones(mustParseZones(t, featureCollection(boxFeature(`"name": "Centre", "multiplier": 2, "surcharge": 3`, 40.74, -74.01, 40.82, -74.0))))
+	zoned := NewMeter(tariff)
+	for _, point := range route {
+		if err := plain.AddPoint(point); err != nil {
+			t.Fatalf("AddPoint failed: %v", err)
+		}
+		if err := zoned.AddPoint(point); err != nil {
+			t.Fatalf("AddPoint failed: %v", err)
+		}
+	}
+
+	// Only the daytime segments have midpoints in Centre
+	p, z := plain.Breakdown(), zoned.Breakdown()
+	if math.Abs(z.MovingDayCost-2*p.MovingDayCost) > 1e-9 || z.MovingNightCost != p.MovingNightCost || z.IdleCost != p.IdleCost {
+		t.Errorf("Expected only the day cost to double, got %+v from %+v", z, p)
+	}
+	if z.MovingDayKm != p.MovingDayKm || z.IdleHours != p.IdleHours {
+		t.Errorf("Expected zones to leave distances and times alone, got %+v from %+v", z, p)
+	}
+	if !reflect.DeepEqual(z.Zones, []string{"Centre"}) || z.ZoneSurcharge != 3 {
+		t.Errorf("Expected Centre to be charged once, got zones %v surcharge %.2f", z.Zones, z.ZoneSurcharge)
+	}
+	if expected := math.Round((p.FlagCharge+2*p.MovingDayCost+p.MovingNightCost+p.IdleCost+3)*100) / 100; zoned.Fare() != expected {
+		t.Errorf("Fare = %.2f, want %.2f", zoned.Fare(), expected)
+	}
+}
+
+func TestMeterRejectsPoints(t *testing.T) {
+	start := meterRoute[1]
+	tests := []struct {
+		name  string
+		point models.DeliveryPoint
+		err   error // nil means any error
+	}{
+		{"Older point", meterRoute[0], ErrPointOutOfOrder},
+		{"Other delivery", models.DeliveryPoint{ID: 2, Timestamp: start.Timestamp.Add(time.Minute)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meter := NewMeter(nil)
+			if err := meter.AddPoint(start); err != nil {
+				t.Fatalf("AddPoint failed: %v", err)
+			}
+			err := meter.AddPoint(tt.point)
+			if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Expected error %v, got %v", tt.err, err)
+			}
+			if meter.Points() != 1 {
+				t.Errorf("Expected the rejected point to be left out, got %d points", meter.Points())
+			}
+		})
+	}
+
+	meter := NewMeter(nil)
+	meter.Finalize()
+	if err := meter.AddPoint(start); !errors.Is(err, ErrMeterFinalized) {
+		t.Errorf("Expected ErrMeterFinalized, got %v", err)
+	}
+}
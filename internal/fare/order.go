@@ -0,0 +1,172 @@
+package fare
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+
+	"SBCFAA/internal/models"
+)
+
+// Order selects the order in which fare estimates are emitted
+type Order int
+
+const (
+	OrderCompletion Order = iota // as workers finish, fastest but not reproducible
+	OrderInput                   // same order as ingestion emitted the deliveries
+	OrderDeliveryID              // ascending id_delivery
+)
+
+// ErrUnsorted is reported when OrderDeliveryID output could not be fully
+// sorted within reorderWindow estimates
+var ErrUnsorted = errors.New("output is not sorted by id_delivery")
+
+// reorderWindow bounds how many deliveries may be in flight between the
+// dispatcher and the reorder buffer, and how many estimates the id sort holds
+const reorderWindow = 1000
+
+// ParseOrder converts a command-line value ("completion", "input" or "id") to an Order
+func ParseOrder(s string) (Order, error) {
+	switch s {
+	case "completion":
+		return OrderCompletion, nil
+	case "input":
+		return OrderInput, nil
+	case "id":
+		return OrderDeliveryID, nil
+	}
+	return 0, fmt.Errorf("unknown order %q (want completion, input or id)", s)
+}
+
+// CalculateFaresOrdered is CalculateFares with a deterministic output order.
+// Estimates are still streamed: at most reorderWindow deliveries are held
+// back while a slow one is being priced.
+//
+// OrderDeliveryID sorts within a further window of reorderWindow estimates,
+// so the output is fully sorted only when no delivery arrives more than
+// reorderWindow positions after a larger id. Input that is already grouped
+// in ascending id order always qualifies. Otherwise the error channel carries
+// ErrUnsorted as soon as the first estimate is emitted out of order, so the
+// caller can stop the input; estimates keep flowing until it does. The error
+// channel is closed with no error for the other orders.
+func CalculateFaresOrdered(deliveries <-chan models.Delivery, tariff *Tariff, order Order) (<-chan models.FareEstimate, <-chan error) {
+	noErr := make(chan error)
+	close(noErr)
+	if order == OrderCompletion {
+		return CalculateFares(deliveries, tariff), noErr
+	}
+	if tariff == nil {
+		tariff = DefaultTariff()
+	}
+
+	// Each dispatched delivery holds a slot until its estimate leaves the
+	// reorder buffer, so the buffer never holds more than reorderWindow entries
+	slots := make(chan struct{}, reorderWindow)
+	gated := make(chan models.Delivery)
+	go func() {
+		defer close(gated)
+		for delivery := range deliveries {
+			slots <- struct{}{}
+			gated <- delivery
+		}
+	}()
+
+	// Parts are reordered like whole deliveries, then joined
+	ordered := joinParts(reorderBySeq(tariff.priceDeliveries(gated), slots))
+	if order == OrderDeliveryID {
+		return sortByDeliveryID(ordered, reorderWindow)
+	}
+	return ordered, noErr
+}
+
+// reorderBySeq emits estimates in Seq order, releasing one slot per estimate
+func reorderBySeq(estimates <-chan models.FareEstimate, slots <-chan struct{}) <-chan models.FareEstimate {
+	orderedChan := make(chan models.FareEstimate, 100)
+
+	go func() {
+		defer close(orderedChan)
+
+		pending := make(map[int64]models.FareEstimate)
+		var next int64
+		for estimate := range estimates {
+			pending[estimate.Seq] = estimate
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				orderedChan <- ready
+				<-slots
+				next++
+			}
+		}
+
+		if len(pending) > 0 { // Only possible if Seq had gaps
+			log.Printf("Warning: %d fare estimates had non-consecutive sequence numbers", len(pending))
+		}
+	}()
+
+	return orderedChan
+}
+
+// sortByDeliveryID emits estimates in ascending DeliveryID using a min-heap
+// of at most window entries. If that is not enough, ErrUnsorted is sent on
+// the error channel before the first estimate out of order.
+func sortByDeliveryID(estimates <-chan models.FareEstimate, window int) (<-chan models.FareEstimate, <-chan error) {
+	sortedChan := make(chan models.FareEstimate, 100)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(sortedChan)
+		defer close(errChan)
+
+		h := &estimateHeap{}
+		var last int64
+		emitted, unsorted := false, false
+		emit := func() {
+			estimate := heap.Pop(h).(models.FareEstimate)
+			if emitted && estimate.DeliveryID < last && !unsorted {
+				errChan <- fmt.Errorf("%w: delivery %d arrived more than %d deliveries after a larger id; use -grouping external", ErrUnsorted, estimate.DeliveryID, window)
+				unsorted = true
+			}
+			last, emitted = estimate.DeliveryID, true
+			sortedChan <- estimate
+		}
+
+		for estimate := range estimates {
+			heap.Push(h, estimate)
+			if h.Len() > window {
+				emit()
+			}
+		}
+		for h.Len() > 0 {
+			emit()
+		}
+	}()
+
+	return sortedChan, errChan
+}
+
+// estimateHeap is a min-heap of estimates by DeliveryID, then Seq
+type estimateHeap []models.FareEstimate
+
+func (h estimateHeap) Len() int { return len(h) }
+func (h estimateHeap) Less(i, j int) bool {
+	if h[i].DeliveryID != h[j].DeliveryID {
+		return h[i].DeliveryID < h[j].DeliveryID
+	}
+	return h[i].Seq < h[j].Seq
+}
+func (h estimateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *estimateHeap) Push(x any) { *h = append(*h, x.(models.FareEstimate)) }
+
+func (h *estimateHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
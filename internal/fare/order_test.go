@@ -0,0 +1,205 @@
+package fare
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// makeDeliveries builds one two-point delivery per id; larger point counts
+// make a delivery slower to price so workers finish out of order
+func makeDeliveries(ids []int64, slowEvery int) []models.Delivery {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	deliveries := make([]models.Delivery, len(ids))
+	for i, id := range ids {
+		count := 2
+		if slowEvery > 0 && i%slowEvery == 0 {
+			count = 2000
+		}
+		points := make([]models.DeliveryPoint, count)
+		for j := range points {
+			points[j] = models.DeliveryPoint{
+				ID:        id,
+				Latitude:  40.7128 + float64(j)*0.001,
+				Longitude: -74.0060,
+				Timestamp: start.Add(time.Duration(j) * time.Minute),
+			}
+		}
+		deliveries[i] = models.Delivery{Seq: int64(i), Points: points}
+	}
+	return deliveries
+}
+
+func runOrdered(t *testing.T, deliveries []models.Delivery, order Order) []models.FareEstimate {
+	t.Helper()
+	deliveriesChan := make(chan models.Delivery)
+	go func() {
+		defer close(deliveriesChan)
+		for _, delivery := range deliveries {
+			deliveriesChan <- delivery
+		}
+	}()
+
+	var results []models.FareEstimate
+	estimatesChan, errChan := CalculateFaresOrdered(deliveriesChan, DefaultTariff(), order)
+	for estimate := range estimatesChan {
+		results = append(results, estimate)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("CalculateFaresOrdered failed: %v", err)
+	}
+	return results
+}
+
+func TestCalculateFaresOrdered(t *testing.T) {
+	ids := make([]int64, 3*reorderWindow)
+	for i := range ids {
+		ids[i] = int64(len(ids) - i) // Descending, so input and id order differ
+	}
+	deliveries := makeDeliveries(ids, 7)
+
+	t.Run("Input order", func(t *testing.T) {
+		results := runOrdered(t, deliveries, OrderInput)
+		if len(results) != len(ids) {
+			t.Fatalf("Expected %d estimates, got %d", len(ids), len(results))
+		}
+		for i, estimate := range results {
+			if estimate.Seq != int64(i) || estimate.DeliveryID != ids[i] {
+				t.Fatalf("Estimate %d: got Seq %d id %d, want Seq %d id %d", i, estimate.Seq, estimate.DeliveryID, i, ids[i])
+			}
+		}
+	})
+
+	t.Run("Completion order keeps every estimate", func(t *testing.T) {
+		results := runOrdered(t, deliveries, OrderCompletion)
+		seen := make(map[int64]bool)
+		for _, estimate := range results {
+			seen[estimate.Seq] = true
+		}
+		if len(seen) != len(ids) {
+			t.Errorf("Expected %d distinct estimates, got %d", len(ids), len(seen))
+		}
+	})
+}
+
+func TestCalculateFaresOrderedByDeliveryID(t *testing.T) {
+	// Locally shuffled ids: every id is less than reorderWindow positions
+	// away from its sorted position
+	ids := make([]int64, 2500)
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	for i := 0; i+10 <= len(ids); i += 10 {
+		for a, b := i, i+9; a < b; a, b = a+1, b-1 {
+			ids[a], ids[b] = ids[b], ids[a]
+		}
+	}
+
+	results := runOrdered(t, makeDeliveries(ids, 11), OrderDeliveryID)
+	if len(results) != len(ids) {
+		t.Fatalf("Expected %d estimates, got %d", len(ids), len(results))
+	}
+	for i, estimate := range results {
+		if estimate.DeliveryID != int64(i) {
+			t.Fatalf("Estimate %d: got id %d, want %d", i, estimate.DeliveryID, i)
+		}
+	}
+}
+
+func TestCalculateFaresOrderedStopsWhenUnsorted(t *testing.T) {
+	// Descending ids overflow the sort window after about two windows'
+	// worth of deliveries; the input stops as soon as the error is reported
+	const total = 50 * reorderWindow
+	deliveriesChan := make(chan models.Delivery)
+	stop := make(chan struct{})
+	sent := 0
+	go func() {
+		defer close(deliveriesChan)
+		for _, delivery := range makeDeliveries(make([]int64, total), 0) {
+			delivery.Seq = int64(sent)
+			delivery.Points[0].ID, delivery.Points[1].ID = int64(total-sent), int64(total-sent)
+			select {
+			case deliveriesChan <- delivery:
+				sent++
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	estimatesChan, errChan := CalculateFaresOrdered(deliveriesChan, DefaultTariff(), OrderDeliveryID)
+	first := make(chan error, 1)
+	go func() {
+		err := <-errChan
+		if err != nil {
+			close(stop)
+		}
+		first <- err
+	}()
+	for range estimatesChan {
+	}
+
+	if err := <-first; !errors.Is(err, ErrUnsorted) {
+		t.Fatalf("Expected ErrUnsorted, got %v", err)
+	}
+	if sent >= total/2 {
+		t.Errorf("Expected the input to stop soon after the error, but %d of %d deliveries were read", sent, total)
+	}
+}
+
+func TestSortByDeliveryIDSmallWindow(t *testing.T) {
+	estimatesChan := make(chan models.FareEstimate, 5)
+	for i, id := range []int64{5, 1, 4, 2, 3} {
+		estimatesChan <- models.FareEstimate{DeliveryID: id, Seq: int64(i)}
+	}
+	close(estimatesChan)
+
+	// A window of 1 can only swap neighbours, so the result stays partial
+	var got []int64
+	sortedChan, errChan := sortByDeliveryID(estimatesChan, 1)
+	for estimate := range sortedChan {
+		got = append(got, estimate.DeliveryID)
+	}
+	if err := <-errChan; !errors.Is(err, ErrUnsorted) {
+		t.Errorf("Expected ErrUnsorted, got %v", err)
+	}
+	expected := []int64{1, 4, 2, 3, 5}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      Order
+		expectedError bool
+	}{
+		{"completion", OrderCompletion, false},
+		{"input", OrderInput, false},
+		{"id", OrderDeliveryID, false},
+		{"random", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseOrder(tt.input)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("ParseOrder(%q) = %v, %v; want %v", tt.input, result, err, tt.expected)
+			}
+		})
+	}
+}
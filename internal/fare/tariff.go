@@ -0,0 +1,153 @@
+package fare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	_ "time/tzdata" // zone database for hosts without /usr/share/zoneinfo
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tariff holds the pricing rules used to turn a delivery into a fare
+type Tariff struct {
+	FlagCharge           float64 `json:"flag_charge" yaml:"flag_charge"`
+	MinimumFare          float64 `json:"minimum_fare" yaml:"minimum_fare"`
+	MovingRateDay        float64 `json:"moving_rate_day" yaml:"moving_rate_day"`               // per km
+	MovingRateNight      float64 `json:"moving_rate_night" yaml:"moving_rate_night"`           // per km
+	IdleRate             float64 `json:"idle_rate" yaml:"idle_rate"`                           // per hour
+	MovingSpeedThreshold float64 `json:"moving_speed_threshold" yaml:"moving_speed_threshold"` // km/hour
+	NightStartHour       int     `json:"night_start_hour" yaml:"night_start_hour"`
+	NightEndHour         int     `json:"night_end_hour" yaml:"night_end_hour"`
+	TimeZone             string  `json:"time_zone" yaml:"time_zone"`   // IANA name used for the night window
+	ZonesFile            string  `json:"zones_file" yaml:"zones_file"` // GeoJSON zones, relative to the tariff file
+
+	location *time.Location
+	zones    *Zones
+}
+
+// DefaultTariff returns the built-in tariff used when no tariff file is given
+func DefaultTariff() *Tariff {
+	return &Tariff{
+		FlagCharge:           FlagCharge,
+		MinimumFare:          MinimumFare,
+		MovingRateDay:        MovingRateDay,
+		MovingRateNight:      MovingRateNight,
+		IdleRate:             IdleRate,
+		MovingSpeedThreshold: MovingSpeedThreshold,
+		NightStartHour:       NightStartHour,
+		NightEndHour:         NightEndHour,
+		TimeZone:             "UTC",
+		location:             time.UTC,
+	}
+}
+
+// SetTimeZone sets the IANA zone (e.g. "Asia/Tehran") in which the night
+// window is evaluated
+func (t *Tariff) SetTimeZone(name string) error {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q: %v", name, err)
+	}
+	t.TimeZone = name
+	t.location = location
+	return nil
+}
+
+// SetZones prices segments inside zones with their own multipliers and
+// surcharges; nil removes them
+func (t *Tariff) SetZones(zones *Zones) {
+	t.zones = zones
+}
+
+// Zones returns the priced zones, nil if there are none
+func (t *Tariff) Zones() *Zones {
+	return t.zones
+}
+
+// Location returns the zone used to decide day vs. night, UTC if none is set
+func (t *Tariff) Location() *time.Location {
+	if t.location == nil {
+		return time.UTC
+	}
+	return t.location
+}
+
+// LoadTariff reads a JSON or YAML tariff file. Fields missing from the file
+// keep their default values.
+func LoadTariff(path string) (*Tariff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tariff := DefaultTariff()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(tariff)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(tariff)
+	default:
+		return nil, fmt.Errorf("unsupported tariff file extension %q (want .json, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tariff %s: %v", path, err)
+	}
+	if err := tariff.SetTimeZone(tariff.TimeZone); err != nil {
+		return nil, fmt.Errorf("invalid tariff %s: %v", path, err)
+	}
+	if tariff.ZonesFile != "" {
+		zonesPath := tariff.ZonesFile
+		if !filepath.IsAbs(zonesPath) {
+			zonesPath = filepath.Join(filepath.Dir(path), zonesPath)
+		}
+		if tariff.zones, err = LoadZones(zonesPath); err != nil {
+			return nil, fmt.Errorf("invalid tariff %s: %v", path, err)
+		}
+	}
+
+	if err := tariff.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tariff %s: %v", path, err)
+	}
+	return tariff, nil
+}
+
+// Validate checks that rates are non-negative and the night window is sane
+func (t *Tariff) Validate() error {
+	rates := []struct {
+		name  string
+		value float64
+	}{
+		{"flag_charge", t.FlagCharge},
+		{"minimum_fare", t.MinimumFare},
+		{"moving_rate_day", t.MovingRateDay},
+		{"moving_rate_night", t.MovingRateNight},
+		{"idle_rate", t.IdleRate},
+		{"moving_speed_threshold", t.MovingSpeedThreshold},
+	}
+	for _, rate := range rates {
+		if math.IsNaN(rate.value) || math.IsInf(rate.value, 0) || rate.value < 0 {
+			return fmt.Errorf("%s must be a non-negative number, got %v", rate.name, rate.value)
+		}
+	}
+
+	if t.NightStartHour < 0 || t.NightStartHour > 23 {
+		return fmt.Errorf("night_start_hour must be between 0 and 23, got %d", t.NightStartHour)
+	}
+	if t.NightEndHour < 0 || t.NightEndHour > 24 {
+		return fmt.Errorf("night_end_hour must be between 0 and 24, got %d", t.NightEndHour)
+	}
+	if t.NightStartHour == t.NightEndHour {
+		return fmt.Errorf("night window %d-%d is empty", t.NightStartHour, t.NightEndHour)
+	}
+	return nil
+}
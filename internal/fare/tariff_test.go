@@ -0,0 +1,237 @@
+package fare
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTariffFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write tariff file: %v", err)
+	}
+	return path
+}
+
+func TestLoadTariff(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		content       string
+		expected      *Tariff
+		expectedError bool
+	}{
+		{
+			name: "Full JSON tariff",
+			file: "tariff.json",
+			content: `{
+				"flag_charge": 2,
+				"minimum_fare": 5,
+				"moving_rate_day": 1,
+				"moving_rate_night": 1.5,
+				"idle_rate": 10,
+				"moving_speed_threshold": 8,
+				"night_start_hour": 22,
+				"night_end_hour": 6
+			}`,
+			expected: &Tariff{
+				FlagCharge:           2,
+				MinimumFare:          5,
+				MovingRateDay:        1,
+				MovingRateNight:      1.5,
+				IdleRate:             10,
+				MovingSpeedThreshold: 8,
+				NightStartHour:       22,
+				NightEndHour:         6,
+				TimeZone:             "UTC",
+				location:             time.UTC,
+			},
+		},
+		{
+			name:    "Time zone",
+			file:    "tariff.yaml",
+			content: "time_zone: Asia/Tehran\n",
+			expected: func() *Tariff {
+				tariff := DefaultTariff()
+				tariff.TimeZone = "Asia/Tehran"
+				return tariff
+			}(),
+		},
+		{
+			name:          "Unknown time zone",
+			file:          "tariff.json",
+			content:       `{"time_zone": "Mars/Olympus_Mons"}`,
+			expectedError: true,
+		},
+		{
+			name:    "Partial YAML tariff keeps defaults",
+			file:    "tariff.yaml",
+			content: "flag_charge: 2.5\nidle_rate: 9\n",
+			expected: func() *Tariff {
+				tariff := DefaultTariff()
+				tariff.FlagCharge = 2.5
+				tariff.IdleRate = 9
+				return tariff
+			}(),
+		},
+		{
+			name:          "Unknown JSON field",
+			file:          "tariff.json",
+			content:       `{"flag_charg": 2}`,
+			expectedError: true,
+		},
+		{
+			name:          "Unknown YAML field",
+			file:          "tariff.yml",
+			content:       "idle: 3\n",
+			expectedError: true,
+		},
+		{
+			name:          "Negative rate",
+			file:          "tariff.json",
+			content:       `{"moving_rate_day": -1}`,
+			expectedError: true,
+		},
+		{
+			name:          "Night hour out of range",
+			file:          "tariff.yaml",
+			content:       "night_start_hour: 25\n",
+			expectedError: true,
+		},
+		{
+			name:          "Empty night window",
+			file:          "tariff.yaml",
+			content:       "night_start_hour: 3\nnight_end_hour: 3\n",
+			expectedError: true,
+		},
+		{
+			name:          "Unsupported extension",
+			file:          "tariff.toml",
+			content:       "flag_charge = 2",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTariffFile(t, tt.file, tt.content)
+			result, err := LoadTariff(path)
+
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			// Compare zones by name, locations are loaded separately
+			got, want := *result, *tt.expected
+			if got.Location().String() != want.TimeZone {
+				t.Errorf("LoadTariff() location = %v, want %v", got.Location(), want.TimeZone)
+			}
+			got.location, want.location = nil, nil
+			if got != want {
+				t.Errorf("LoadTariff() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadTariffZonesFile(t *testing.T) {
+	dir := t.TempDir()
+	zones := featureCollection(boxFeature(`"name": "Airport", "surcharge": 3.5`, 35.40, 51.14, 35.43, 51.18))
+	if err := os.WriteFile(filepath.Join(dir, "zones.geojson"), []byte(zones), 0o644); err != nil {
+		t.Fatalf("Failed to write zones file: %v", err)
+	}
+	path := filepath.Join(dir, "tariff.yaml")
+	if err := os.WriteFile(path, []byte("zones_file: zones.geojson\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write tariff file: %v", err)
+	}
+
+	tariff, err := LoadTariff(path)
+	if err != nil {
+		t.Fatalf("LoadTariff failed: %v", err)
+	}
+	if zone := tariff.Zones().Find(35.41, 51.15); zone == nil || zone.Name != "Airport" {
+		t.Errorf("Expected the zones file next to the tariff to be loaded, got %v", zone)
+	}
+
+	missing := writeTariffFile(t, "tariff.yaml", "zones_file: zones.geojson\n")
+	if _, err := LoadTariff(missing); err == nil {
+		t.Errorf("Expected an error for a missing zones file, but got none")
+	}
+}
+
+func TestLoadTariffMissingFile(t *testing.T) {
+	if _, err := LoadTariff(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected an error for a missing file, but got none")
+	}
+}
+
+func TestIsNightTimeWrappingWindow(t *testing.T) {
+	tariff := DefaultTariff()
+	tariff.NightStartHour = 22
+	tariff.NightEndHour = 6
+
+	tests := []struct {
+		name     string
+		time     time.Time
+		expected bool
+	}{
+		{"Before window", time.Date(2023, 1, 1, 21, 59, 59, 0, time.UTC), false},
+		{"Window start", time.Date(2023, 1, 1, 22, 0, 0, 0, time.UTC), true},
+		{"Midnight", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Just before window end", time.Date(2023, 1, 1, 5, 59, 59, 0, time.UTC), true},
+		{"Window end", time.Date(2023, 1, 1, 6, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tariff.isNightTime(tt.time); result != tt.expected {
+				t.Errorf("isNightTime() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsNightTimeInTariffZone(t *testing.T) {
+	tariff := DefaultTariff()
+	if err := tariff.SetTimeZone("Asia/Tehran"); err != nil { // UTC+03:30
+		t.Fatalf("SetTimeZone failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		time     time.Time
+		expected bool
+	}{
+		{"Tehran midnight", time.Date(2023, 1, 1, 20, 30, 0, 0, time.UTC), true},
+		{"Tehran 04:59", time.Date(2023, 1, 2, 1, 29, 0, 0, time.UTC), true},
+		{"Tehran 05:00", time.Date(2023, 1, 2, 1, 30, 0, 0, time.UTC), false},
+		{"UTC midnight is Tehran 03:30", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"UTC 20:00 is Tehran 23:30", time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tariff.isNightTime(tt.time); result != tt.expected {
+				t.Errorf("isNightTime() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetTimeZoneUnknown(t *testing.T) {
+	tariff := DefaultTariff()
+	if err := tariff.SetTimeZone("Not/AZone"); err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+	if tariff.TimeZone != "UTC" {
+		t.Errorf("Failed SetTimeZone changed TimeZone to %q", tariff.TimeZone)
+	}
+}
@@ -0,0 +1,95 @@
+package fare
+
+import "math"
+
+// maxGridSide caps the cells along each side of the zone grid
+const maxGridSide = 512
+
+// Zones is a set of zones with a grid index over their bounding boxes, so
+// finding the zone of a point costs about the same with ten zones as with
+// ten thousand: only the zones whose boxes overlap the point's cell are
+// tested, in the order they were listed.
+type Zones struct {
+	zones  []Zone
+	byName map[string]*Zone
+	bounds bounds
+
+	rows, cols       int
+	cellLat, cellLng float64   // cell size in degrees
+	cells            [][]int32 // per cell, the zones overlapping it, in list order
+}
+
+// newZones indexes zones, which must have distinct names
+func newZones(zones []Zone) *Zones {
+	z := &Zones{zones: zones, byName: make(map[string]*Zone, len(zones)), bounds: emptyBounds()}
+	for i := range zones {
+		z.byName[zones[i].Name] = &zones[i]
+		z.bounds.extend(zones[i].bounds)
+	}
+	if len(zones) == 0 {
+		return z
+	}
+
+	// About four cells per zone, on a grid as square as the cells allow
+	side := int(math.Ceil(2 * math.Sqrt(float64(len(zones)))))
+	side = min(max(side, 1), maxGridSide)
+	z.rows, z.cols = side, side
+	z.cellLat = cellSize(z.bounds.maxLat-z.bounds.minLat, side)
+	z.cellLng = cellSize(z.bounds.maxLng-z.bounds.minLng, side)
+
+	z.cells = make([][]int32, z.rows*z.cols)
+	for i, zone := range zones {
+		firstRow, firstCol := z.cell(zone.bounds.minLat, zone.bounds.minLng)
+		lastRow, lastCol := z.cell(zone.bounds.maxLat, zone.bounds.maxLng)
+		for row := firstRow; row <= lastRow; row++ {
+			for col := firstCol; col <= lastCol; col++ {
+				z.cells[row*z.cols+col] = append(z.cells[row*z.cols+col], int32(i))
+			}
+		}
+	}
+	return z
+}
+
+func cellSize(span float64, cells int) float64 {
+	if span <= 0 {
+		return 1 // Every zone is on one line; a single cell holds them all
+	}
+	return span / float64(cells)
+}
+
+// cell returns the grid cell of a point inside the bounds
+func (z *Zones) cell(lat, lng float64) (row, col int) {
+	row = min(int((lat-z.bounds.minLat)/z.cellLat), z.rows-1)
+	col = min(int((lng-z.bounds.minLng)/z.cellLng), z.cols-1)
+	return max(row, 0), max(col, 0)
+}
+
+// Find returns the first zone containing the point, or nil if there is none
+func (z *Zones) Find(lat, lng float64) *Zone {
+	if z == nil || len(z.zones) == 0 || !z.bounds.contains(lat, lng) {
+		return nil
+	}
+	row, col := z.cell(lat, lng)
+	for _, i := range z.cells[row*z.cols+col] {
+		if z.zones[i].Contains(lat, lng) {
+			return &z.zones[i]
+		}
+	}
+	return nil
+}
+
+// Zone returns the zone called name, or nil
+func (z *Zones) Zone(name string) *Zone {
+	if z == nil {
+		return nil
+	}
+	return z.byName[name]
+}
+
+// Len returns the number of zones
+func (z *Zones) Len() int {
+	if z == nil {
+		return 0
+	}
+	return len(z.zones)
+}
@@ -0,0 +1,64 @@
+package fare
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomZones returns n triangles and boxes of varied size scattered over a
+// city-sized area, many of them overlapping
+func randomZones(t testing.TB, n int, rng *rand.Rand) *Zones {
+	features := make([]string, n)
+	for i := range features {
+		lat, lng := 35+rng.Float64(), 51+rng.Float64()
+		size := 0.001 + rng.Float64()*0.05
+		if i%2 == 0 {
+			features[i] = boxFeature(fmt.Sprintf(`"name": "box %d"`, i), lat, lng, lat+size, lng+size)
+			continue
+		}
+		features[i] = fmt.Sprintf(`{"type": "Feature", "properties": {"name": "triangle %d"}, "geometry": {"type": "Polygon", "coordinates": [[[%g, %g], [%g, %g], [%g, %g]]]}}`,
+			i, lng, lat, lng+size, lat, lng, lat+size)
+	}
+	return mustParseZones(t, featureCollection(features...))
+}
+
+func TestZonesFindMatchesLinearSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 10, 3000} {
+		zones := randomZones(t, n, rng)
+		for i := 0; i < 20000; i++ {
+			lat, lng := 34.9+rng.Float64()*1.2, 50.9+rng.Float64()*1.2
+
+			var expected *Zone
+			for j := range zones.zones {
+				if zones.zones[j].Contains(lat, lng) {
+					expected = &zones.zones[j]
+					break
+				}
+			}
+			if found := zones.Find(lat, lng); found != expected {
+				t.Fatalf("%d zones: Find(%v, %v) = %v, want %v", n, lat, lng, found, expected)
+			}
+		}
+	}
+}
+
+func TestZonesNil(t *testing.T) {
+	var zones *Zones
+	if zones.Find(0, 0) != nil || zones.Zone("A") != nil || zones.Len() != 0 {
+		t.Errorf("Expected nil zones to be empty")
+	}
+	if empty := mustParseZones(t, featureCollection()); empty.Find(0, 0) != nil || empty.Len() != 0 {
+		t.Errorf("Expected a collection without features to be empty")
+	}
+}
+
+func BenchmarkZonesFind(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	zones := randomZones(b, 5000, rng)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zones.Find(35+rng.Float64(), 51+rng.Float64())
+	}
+}
@@ -0,0 +1,190 @@
+package fare
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Zone is an area priced differently from the rest of the map, such as an
+// airport or a restricted traffic zone, loaded from one GeoJSON feature
+type Zone struct {
+	Name       string
+	Multiplier float64 // scales the moving and idle costs of segments in the zone
+	Surcharge  float64 // added once to the fare of a delivery that passes through
+
+	polygons []polygon
+	bounds   bounds
+}
+
+// Contains reports whether the point lies inside the zone
+func (z *Zone) Contains(lat, lng float64) bool {
+	if !z.bounds.contains(lat, lng) {
+		return false
+	}
+	for _, p := range z.polygons {
+		if p.contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// polygon is an outer ring followed by its holes. Rings are closed
+// implicitly; the even-odd rule over all of them leaves the holes out.
+type polygon struct {
+	rings  [][]vertex
+	bounds bounds
+}
+
+type vertex struct{ lat, lng float64 }
+
+func (p *polygon) contains(lat, lng float64) bool {
+	if !p.bounds.contains(lat, lng) {
+		return false
+	}
+	inside := false
+	for _, ring := range p.rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.lat > lat) != (b.lat > lat) && lng < (b.lng-a.lng)*(lat-a.lat)/(b.lat-a.lat)+a.lng {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// bounds is a bounding box in degrees
+type bounds struct{ minLat, minLng, maxLat, maxLng float64 }
+
+func emptyBounds() bounds {
+	return bounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+}
+
+func (b *bounds) extend(o bounds) {
+	b.minLat, b.minLng = math.Min(b.minLat, o.minLat), math.Min(b.minLng, o.minLng)
+	b.maxLat, b.maxLng = math.Max(b.maxLat, o.maxLat), math.Max(b.maxLng, o.maxLng)
+}
+
+func (b bounds) contains(lat, lng float64) bool {
+	return lat >= b.minLat && lat <= b.maxLat && lng >= b.minLng && lng <= b.maxLng
+}
+
+// geoJSON is the part of a GeoJSON FeatureCollection that zones are read from
+type geoJSON struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Name       string   `json:"name"`
+			Multiplier *float64 `json:"multiplier"`
+			Surcharge  float64  `json:"surcharge"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// LoadZones reads zones from a GeoJSON file, see ParseZones
+func LoadZones(path string) (*Zones, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	zones, err := ParseZones(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zones %s: %v", path, err)
+	}
+	return zones, nil
+}
+
+// ParseZones reads a GeoJSON FeatureCollection of Polygon and MultiPolygon
+// features. Each feature's properties may set its name, its multiplier
+// (default 1) and its surcharge (default 0); unnamed features are called
+// "zone N" after their position. Where zones overlap, the first one listed wins.
+func ParseZones(data []byte) (*Zones, error) {
+	var collection geoJSON
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("want a FeatureCollection, got %q", collection.Type)
+	}
+
+	zones := make([]Zone, len(collection.Features))
+	names := make(map[string]bool, len(zones))
+	for i, feature := range collection.Features {
+		zone := Zone{Name: feature.Properties.Name, Multiplier: 1, Surcharge: feature.Properties.Surcharge, bounds: emptyBounds()}
+		if zone.Name == "" {
+			zone.Name = fmt.Sprintf("zone %d", i+1)
+		}
+		if names[zone.Name] {
+			return nil, fmt.Errorf("two zones are called %q", zone.Name)
+		}
+		names[zone.Name] = true
+		if feature.Properties.Multiplier != nil {
+			zone.Multiplier = *feature.Properties.Multiplier
+		}
+		if !isRate(zone.Multiplier) || !isRate(zone.Surcharge) {
+			return nil, fmt.Errorf("%s: multiplier and surcharge must be non-negative numbers", zone.Name)
+		}
+
+		var err error
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var rings [][][]float64
+			if err = json.Unmarshal(feature.Geometry.Coordinates, &rings); err == nil {
+				err = zone.addPolygon(rings)
+			}
+		case "MultiPolygon":
+			var polygons [][][][]float64
+			if err = json.Unmarshal(feature.Geometry.Coordinates, &polygons); err == nil {
+				for _, rings := range polygons {
+					if err = zone.addPolygon(rings); err != nil {
+						break
+					}
+				}
+			}
+		default:
+			err = fmt.Errorf("want a Polygon or MultiPolygon, got %q", feature.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", zone.Name, err)
+		}
+		zones[i] = zone
+	}
+	return newZones(zones), nil
+}
+
+// addPolygon adds GeoJSON polygon rings, in [longitude, latitude] order
+func (z *Zone) addPolygon(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("polygon has no rings")
+	}
+	p := polygon{bounds: emptyBounds()}
+	for _, positions := range rings {
+		if len(positions) < 3 {
+			return fmt.Errorf("ring has %d positions, want at least 3", len(positions))
+		}
+		ring := make([]vertex, len(positions))
+		for i, position := range positions {
+			if len(position) < 2 {
+				return fmt.Errorf("position has %d coordinates, want 2", len(position))
+			}
+			ring[i] = vertex{lat: position[1], lng: position[0]}
+			p.bounds.extend(bounds{position[1], position[0], position[1], position[0]})
+		}
+		p.rings = append(p.rings, ring)
+	}
+	z.polygons = append(z.polygons, p)
+	z.bounds.extend(p.bounds)
+	return nil
+}
+
+func isRate(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0) && value >= 0
+}
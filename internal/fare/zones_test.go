@@ -0,0 +1,128 @@
+package fare
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// boxFeature is a GeoJSON feature for the box from (minLat, minLng) to
+// (maxLat, maxLng); properties is the JSON object body, without braces
+func boxFeature(properties string, minLat, minLng, maxLat, maxLng float64) string {
+	return fmt.Sprintf(`{"type": "Feature", "properties": {%s}, "geometry": {"type": "Polygon", "coordinates": [%s]}}`,
+		properties, boxRing(minLat, minLng, maxLat, maxLng))
+}
+
+func boxRing(minLat, minLng, maxLat, maxLng float64) string {
+	return fmt.Sprintf("[[%[2]g, %[1]g], [%[4]g, %[1]g], [%[4]g, %[3]g], [%[2]g, %[3]g], [%[2]g, %[1]g]]", minLat, minLng, maxLat, maxLng)
+}
+
+func featureCollection(features ...string) string {
+	return `{"type": "FeatureCollection", "features": [` + strings.Join(features, ",") + `]}`
+}
+
+func mustParseZones(t testing.TB, data string) *Zones {
+	t.Helper()
+	zones, err := ParseZones([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseZones failed: %v", err)
+	}
+	return zones
+}
+
+func TestParseZones(t *testing.T) {
+	zones := mustParseZones(t, featureCollection(
+		// A ring road with the centre cut out
+		`{"type": "Feature", "properties": {"name": "Ring", "multiplier": 1.5, "surcharge": 2},
+		  "geometry": {"type": "Polygon", "coordinates": [`+boxRing(0, 0, 10, 10)+`, `+boxRing(4, 4, 6, 6)+`]}}`,
+		// Two islands
+		`{"type": "Feature", "properties": {"multiplier": 0},
+		  "geometry": {"type": "MultiPolygon", "coordinates": [[`+boxRing(20, 20, 21, 21)+`], [`+boxRing(30, 30, 31, 31)+`]]}}`,
+		// Overlaps the ring, which is listed first
+		boxFeature(`"name": "Overlap", "multiplier": 3`, 8, 8, 12, 12),
+	))
+
+	if zones.Len() != 3 {
+		t.Fatalf("Expected 3 zones, got %d", zones.Len())
+	}
+	ring := zones.Zone("Ring")
+	if ring == nil || ring.Multiplier != 1.5 || ring.Surcharge != 2 {
+		t.Errorf("Expected Ring with multiplier 1.5 and surcharge 2, got %+v", ring)
+	}
+	if islands := zones.Zone("zone 2"); islands == nil || islands.Multiplier != 0 {
+		t.Errorf("Expected the unnamed zone to be called \"zone 2\" with multiplier 0, got %+v", islands)
+	}
+	if overlap := zones.Zone("Overlap"); overlap == nil || overlap.Surcharge != 0 {
+		t.Errorf("Expected Overlap with no surcharge, got %+v", overlap)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lng float64
+		expected string // "" means no zone
+	}{
+		{"Ring road", 2, 2, "Ring"},
+		{"Hole", 5, 5, ""},
+		{"First island", 20.5, 20.5, "zone 2"},
+		{"Second island", 30.5, 30.5, "zone 2"},
+		{"Between islands", 25, 25, ""},
+		{"Overlap goes to the first zone", 9, 9, "Ring"},
+		{"Only in the second zone", 11, 11, "Overlap"},
+		{"Outside every zone", -1, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := ""
+			if zone := zones.Find(tt.lat, tt.lng); zone != nil {
+				name = zone.Name
+			}
+			if name != tt.expected {
+				t.Errorf("Find(%v, %v) = %q, want %q", tt.lat, tt.lng, name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseZonesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Not JSON", `{`},
+		{"Not a FeatureCollection", `{"type": "Feature"}`},
+		{"Point geometry", featureCollection(`{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 2]}}`)},
+		{"Duplicate names", featureCollection(boxFeature(`"name": "A"`, 0, 0, 1, 1), boxFeature(`"name": "A"`, 2, 2, 3, 3))},
+		{"Negative multiplier", featureCollection(boxFeature(`"multiplier": -1`, 0, 0, 1, 1))},
+		{"Negative surcharge", featureCollection(boxFeature(`"surcharge": -1`, 0, 0, 1, 1))},
+		{"Short ring", featureCollection(`{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 1]]]}}`)},
+		{"Short position", featureCollection(`{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[0], [1, 0], [1, 1]]]}}`)},
+		{"No rings", featureCollection(`{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": []}}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseZones([]byte(tt.data)); err == nil {
+				t.Errorf("Expected an error, but got none")
+			}
+		})
+	}
+}
+
+func TestLoadZones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zones.geojson")
+	if err := os.WriteFile(path, []byte(featureCollection(boxFeature(`"name": "A"`, 0, 0, 1, 1))), 0o644); err != nil {
+		t.Fatalf("Failed to write zones file: %v", err)
+	}
+	zones, err := LoadZones(path)
+	if err != nil {
+		t.Fatalf("LoadZones failed: %v", err)
+	}
+	if zones.Zone("A") == nil {
+		t.Errorf("Expected zone A to be loaded")
+	}
+
+	if _, err := LoadZones(filepath.Join(t.TempDir(), "missing.geojson")); err == nil {
+		t.Errorf("Expected an error for a missing file, but got none")
+	}
+}
@@ -0,0 +1,242 @@
+package ingestion
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"SBCFAA/internal/models"
+)
+
+// defaultChunkSize is the nominal size of the byte ranges parsed in parallel
+const defaultChunkSize = 4 << 20
+
+// boundaryWindow is how much is read at a time when looking for the end of
+// the line a chunk boundary falls in
+const boundaryWindow = 64 << 10
+
+// chunkItem is one parsed row of a chunk, or the reject it produced. Lines
+// are relative to the start of the chunk until the chunk is stitched.
+type chunkItem struct {
+	row    row
+	reject *models.RejectedRow
+}
+
+type chunkResult struct {
+	items []chunkItem
+	start int64 // offset of the chunk in the file; row offsets are relative to it
+	lines int64 // line breaks in the chunk, up to cut if there is one
+	cut   int64 // where a record runs on past the chunk, relative to start; -1 if none
+	err   error
+}
+
+// readFileChunked reads an uncompressed file by splitting it into byte ranges
+// at line boundaries, parsing them on opts.ParseWorkers goroutines, and
+// feeding the rows to group in file order, so deliveries spanning chunks are
+// grouped as if read sequentially. It returns ok false, having read nothing,
+// when the file is compressed or not a regular file.
+//
+// Chunk boundaries are placed at line breaks without knowing whether they
+// fall inside a quoted field. Once a chunk ends inside one, later chunks
+// cannot be trusted, so the rest of the file is read sequentially.
+func readFileChunked(ctx context.Context, path string, opts Options, index int, resume *models.InputPosition, ordinal *int64, group grouper, reject func(models.RejectedRow)) (rows int64, ok bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false, nil // Let the sequential path report it
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false, nil
+	}
+	size := info.Size()
+	head := make([]byte, len(zstdMagic))
+	n, _ := file.ReadAt(head, 0)
+	if DetectCompression(head[:n], path) != CompressionNone {
+		return 0, false, nil
+	}
+
+	// The header is read like any other input; data starts right after it
+	headerReader := csv.NewReader(io.NewSectionReader(file, 0, size))
+	headerReader.FieldsPerRecord = -1
+	header, err := headerReader.Read()
+	if err != nil {
+		return 0, true, fmt.Errorf("%w: reading header: %v", ErrUnreadableInput, err)
+	}
+	columns, err := newColumnMap(header, opts.ColumnAliases, opts.TimestampFormat)
+	if err != nil {
+		return 0, true, fmt.Errorf("%w: %v", ErrUnreadableInput, err)
+	}
+	start := headerReader.InputOffset()
+	headerBytes := make([]byte, start)
+	if _, err := file.ReadAt(headerBytes, 0); err != nil {
+		return 0, true, err
+	}
+	line := int64(bytes.Count(headerBytes, []byte{'\n'})) // Lines before the current chunk
+	if resume != nil {
+		if resume.Offset < start {
+			return 0, true, fmt.Errorf("resume offset %d is inside the header", resume.Offset)
+		}
+		start, line = resume.Offset, resume.Line-1
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stops the workers if stitching fails
+
+	// Chunks are handed out in file order; each gets its own result channel,
+	// queued on results in the same order for stitching. The queue's capacity
+	// caps the parsed chunks waiting in memory.
+	type job struct {
+		start, end int64
+		result     chan chunkResult
+	}
+	jobs := make(chan job)
+	results := make(chan chan chunkResult, 2*opts.ParseWorkers)
+
+	go func() {
+		defer close(results)
+		defer close(jobs)
+		for start < size {
+			end, err := nextLineStart(file, start+chunkSize, size)
+			result := make(chan chunkResult, 1)
+			if err != nil {
+				result <- chunkResult{err: err}
+			}
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case jobs <- job{start: start, end: end, result: result}:
+			case <-ctx.Done():
+				return
+			}
+			start = end
+		}
+	}()
+
+	for i := 0; i < opts.ParseWorkers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- parseChunk(file, j.start, j.end, columns)
+			}
+		}()
+	}
+
+	for result := range results {
+		var chunk chunkResult
+		select {
+		case chunk = <-result:
+		case <-ctx.Done():
+			return rows, true, ctx.Err()
+		}
+		if chunk.err != nil {
+			return rows, true, chunk.err
+		}
+
+		for _, item := range chunk.items {
+			rows++
+			if item.reject != nil {
+				item.reject.Line += line
+				reject(*item.reject)
+				continue
+			}
+			item.row.line += line
+			item.row.offset += chunk.start
+			item.row.ordinal = *ordinal
+			item.row.source = index
+			if err := group.add(item.row); err != nil {
+				return rows, true, err
+			}
+			*ordinal++
+		}
+		line += chunk.lines
+
+		if chunk.cut >= 0 {
+			cancel()
+			resumeAt := models.InputPosition{Offset: chunk.start + chunk.cut, Line: line + 1}
+			more, err := readSource(parent, io.NewSectionReader(file, 0, size), FormatCSV, opts, index, &resumeAt, ordinal, group, reject)
+			return rows + more, true, err
+		}
+	}
+	return rows, true, ctx.Err()
+}
+
+// nextLineStart returns the offset just after the first line break at or
+// after offset-1, or size if there is none
+func nextLineStart(file io.ReaderAt, offset, size int64) (int64, error) {
+	if offset >= size {
+		return size, nil
+	}
+	offset-- // A boundary right after a line break stays where it is
+	buf := make([]byte, boundaryWindow)
+	for offset < size {
+		n, err := file.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		offset += int64(n)
+	}
+	return size, nil
+}
+
+// parseChunk parses the whole lines in [start, end) with the sequential
+// reader's record splitting, so chunks take the same fast path
+func parseChunk(file io.ReaderAt, start, end int64, columns *columnMap) chunkResult {
+	buf := make([]byte, end-start)
+	if _, err := file.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return chunkResult{err: err}
+	}
+
+	lines := bytes.Count(buf, []byte{'\n'})
+	result := chunkResult{start: start, lines: int64(lines), cut: -1, items: make([]chunkItem, 0, lines+1)}
+	records := newRecordReader(bytes.NewReader(buf))
+	for {
+		record, line, quoted, err := records.next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			return chunkResult{err: err}
+		}
+		if quoted && endsInQuotedField(record) {
+			// The quote is closed in a later chunk, if at all
+			result.cut = records.start
+			result.lines = int64(bytes.Count(buf[:records.start], []byte{'\n'}))
+			return result
+		}
+
+		parsed, rejected := parseRecord(record, line, quoted, columns)
+		if rejected != nil {
+			result.items = append(result.items, chunkItem{reject: rejected})
+			continue
+		}
+		// Raw records must outlive the reader's buffer: unquoted ones are
+		// still in buf, quoted ones may have been joined from several lines
+		if parsed.raw != nil && !quoted {
+			parsed.raw = buf[records.start : records.start+int64(len(parsed.raw))]
+		} else if parsed.raw != nil {
+			parsed.raw = bytes.Clone(parsed.raw)
+		}
+		parsed.offset = records.start
+		result.items = append(result.items, chunkItem{row: parsed})
+	}
+}
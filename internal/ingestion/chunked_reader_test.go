@@ -0,0 +1,191 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"SBCFAA/internal/models"
+)
+
+// generateCSV writes deliveries of pointsPerDelivery rows each, ~36 km/h
+// apart, with every badEvery-th row unparseable (0 for none)
+func generateCSV(tb testing.TB, deliveries, pointsPerDelivery, badEvery int) string {
+	tb.Helper()
+	var b strings.Builder
+	b.WriteString("id_delivery,lat,lng,timestamp\n")
+	n := 0
+	for id := 1; id <= deliveries; id++ {
+		for i := 0; i < pointsPerDelivery; i++ {
+			n++
+			if badEvery > 0 && n%badEvery == 0 {
+				fmt.Fprintf(&b, "%d,bad,51.4000,%d\n", id, 1609459200+60*i)
+				continue
+			}
+			fmt.Fprintf(&b, "%d,%.4f,51.4000,%d\n", id, 35.7+0.0054*float64(i), 1609459200+60*i)
+		}
+	}
+	path := filepath.Join(tb.TempDir(), "generated.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		tb.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+func readAll(t *testing.T, paths []string, opts Options) ([]models.Delivery, []models.RejectedRow, []SourceSummary) {
+	t.Helper()
+	var rejects []models.RejectedRow
+	var summaries []SourceSummary
+	opts.OnReject = func(row models.RejectedRow) { rejects = append(rejects, row) }
+	opts.OnSource = func(summary SourceSummary) { summaries = append(summaries, summary) }
+	pointsChan, errChan := ReadAndFilterCSVFiles(context.Background(), paths, opts)
+	return collectDeliveries(t, pointsChan, errChan), rejects, summaries
+}
+
+func TestChunkedMatchesSequential(t *testing.T) {
+	paths := []string{generateCSV(t, 40, 7, 11), generateCSV(t, 3, 5, 0)}
+	// Line numbers restart in the second file
+	expectedDeliveries, expectedRejects, expectedSummaries := readAll(t, paths, Options{})
+
+	for _, chunkSize := range []int64{1, 7, 100, 1 << 20} {
+		for _, workers := range []int{2, 5} {
+			deliveries, rejects, summaries := readAll(t, paths, Options{ParseWorkers: workers, ChunkSize: chunkSize})
+			if !reflect.DeepEqual(deliveries, expectedDeliveries) {
+				t.Errorf("chunk %d, %d workers: deliveries differ from the sequential reader", chunkSize, workers)
+			}
+			if !reflect.DeepEqual(rejects, expectedRejects) {
+				t.Errorf("chunk %d, %d workers: rejects %+v, want %+v", chunkSize, workers, rejects, expectedRejects)
+			}
+			if !reflect.DeepEqual(summaries, expectedSummaries) {
+				t.Errorf("chunk %d, %d workers: summaries %+v, want %+v", chunkSize, workers, summaries, expectedSummaries)
+			}
+		}
+	}
+}
+
+func TestChunkedQuotedLineBreaks(t *testing.T) {
+	// Every third row has a note spanning two lines; the last row never
+	// closes its quote
+	var b strings.Builder
+	b.WriteString("id_delivery,lat,lng,timestamp,note\n")
+	for i := 0; i < 200; i++ {
+		note := "plain"
+		if i%3 == 0 {
+			note = fmt.Sprintf("\"gate %d,\nring \"\"twice\"\"\"", i)
+		}
+		fmt.Fprintf(&b, "%d,%.4f,51.4000,%d,%s\n", 1+i/20, 35.7+0.0054*float64(i%20), 1609459200+60*(i%20), note)
+	}
+	b.WriteString("99,35.7,51.4,1609459200,\"unterminated\n")
+	path := filepath.Join(t.TempDir(), "notes.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+
+	expectedDeliveries, expectedRejects, expectedSummaries := readAll(t, []string{path}, Options{})
+	if points := countPoints(expectedDeliveries); points != 200 || len(expectedRejects) != 1 {
+		t.Fatalf("Sequential reader kept %d points and rejected %d rows, want 200 and 1", points, len(expectedRejects))
+	}
+	for _, chunkSize := range []int64{1, 30, 100, 1 << 20} {
+		deliveries, rejects, summaries := readAll(t, []string{path}, Options{ParseWorkers: 3, ChunkSize: chunkSize})
+		if !reflect.DeepEqual(deliveries, expectedDeliveries) {
+			t.Errorf("chunk %d: kept %d points, want the sequential reader's 200", chunkSize, countPoints(deliveries))
+		}
+		if !reflect.DeepEqual(rejects, expectedRejects) || !reflect.DeepEqual(summaries, expectedSummaries) {
+			t.Errorf("chunk %d: rejects %+v summaries %+v, want %+v %+v", chunkSize, rejects, summaries, expectedRejects, expectedSummaries)
+		}
+	}
+}
+
+func countPoints(deliveries []models.Delivery) int {
+	n := 0
+	for _, delivery := range deliveries {
+		n += len(delivery.Points)
+	}
+	return n
+}
+
+func TestChunkedFallsBackForCompressedInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.csv.gz")
+	if err := os.WriteFile(path, gzipBytes(t, compressionInput), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+
+	deliveries, _, _ := readAll(t, []string{path}, Options{ParseWorkers: 4, ChunkSize: 8})
+	if len(deliveries) != 2 || len(deliveries[0].Points) != 2 || len(deliveries[1].Points) != 1 {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestNextLineStart(t *testing.T) {
+	content := strings.NewReader("ab\ncd\n\nefg")
+	size := content.Size()
+	for _, tt := range []struct {
+		offset, expected int64
+	}{
+		{1, 3},  // Inside the first line
+		{3, 3},  // Already at a line start
+		{4, 6},  // Inside the second line
+		{7, 7},  // After an empty line
+		{8, 10}, // Last line has no line break
+		{20, 10},
+	} {
+		result, err := nextLineStart(content, tt.offset, size)
+		if err != nil || result != tt.expected {
+			t.Errorf("nextLineStart(%d) = %d, %v; want %d", tt.offset, result, err, tt.expected)
+		}
+	}
+}
+
+func TestParseChunkAllocations(t *testing.T) {
+	file, err := os.Open(generateCSV(t, 40, 25, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skip the header; the rest is 1000 rows in the fixed layout
+	start := int64(len("id_delivery,lat,lng,timestamp\n"))
+	allocs := testing.AllocsPerRun(10, func() {
+		if chunk := parseChunk(file, start, info.Size(), positionalColumns); len(chunk.items) != 1000 {
+			t.Fatalf("Expected 1000 rows, got %d", len(chunk.items))
+		}
+	})
+	if allocs > 10 {
+		t.Errorf("parseChunk allocates %v times for 1000 rows, want the fast path's handful per chunk", allocs)
+	}
+}
+
+func BenchmarkReadAndFilterCSV(b *testing.B) {
+	path := generateCSV(b, 20000, 25, 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	workerCounts := []int{1, 2, 4}
+	if cpus := runtime.NumCPU(); cpus > 4 {
+		workerCounts = append(workerCounts, cpus)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(info.Size())
+			for i := 0; i < b.N; i++ {
+				pointsChan, errChan := ReadAndFilterCSVWithOptions(path, Options{ParseWorkers: workers, Filters: FilterChain{}})
+				for range pointsChan {
+				}
+				if err := <-errChan; err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
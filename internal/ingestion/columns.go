@@ -0,0 +1,169 @@
+package ingestion
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+// Canonical input column names
+const (
+	ColumnID        = "id_delivery"
+	ColumnLat       = "lat"
+	ColumnLng       = "lng"
+	ColumnTimestamp = "timestamp"
+	ColumnAccuracy  = "accuracy" // optional, in meters
+)
+
+// DefaultColumnAliases lists the header names accepted for each column.
+// Headers are matched case-insensitively, ignoring surrounding spaces.
+var DefaultColumnAliases = map[string][]string{
+	ColumnID:        {"id_delivery", "delivery_id", "id"},
+	ColumnLat:       {"lat", "latitude"},
+	ColumnLng:       {"lng", "lon", "long", "longitude"},
+	ColumnTimestamp: {"timestamp", "ts", "time"},
+	ColumnAccuracy:  {"accuracy", "accuracy_m", "horizontal_accuracy"},
+}
+
+var requiredColumns = []string{ColumnID, ColumnLat, ColumnLng, ColumnTimestamp}
+
+// ParseColumnAliases parses "column=name,column=name", e.g.
+// "id_delivery=trip,lat=y", into extra aliases for Options.ColumnAliases
+func ParseColumnAliases(s string) (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	for _, pair := range strings.Split(s, ",") {
+		column, name, ok := strings.Cut(pair, "=")
+		column, name = strings.TrimSpace(column), strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("column alias %q: want column=name", pair)
+		}
+		if _, known := DefaultColumnAliases[column]; !known {
+			return nil, fmt.Errorf("column alias %q: unknown column %q", pair, column)
+		}
+		aliases[column] = append(aliases[column], name)
+	}
+	return aliases, nil
+}
+
+// columnMap locates the columns of one input by position
+type columnMap struct {
+	id, lat, lng, timestamp int
+	accuracy                int      // -1 when the input has no accuracy column
+	width                   int      // number of columns every row must have
+	extra                   []int    // positions of unrecognised columns
+	names                   []string // header names, for extra columns
+	format                  utils.TimestampFormat
+}
+
+// positionalColumns is the original fixed layout: id, lat, lng, timestamp
+var positionalColumns = &columnMap{id: 0, lat: 1, lng: 2, timestamp: 3, accuracy: -1, width: 4}
+
+// newColumnMap matches a header row against DefaultColumnAliases plus aliases
+func newColumnMap(header []string, aliases map[string][]string, format utils.TimestampFormat) (*columnMap, error) {
+	lookup := aliasLookup(aliases)
+
+	positions := make(map[string]int)
+	m := &columnMap{width: len(header), names: make([]string, len(header)), format: format}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // Excel's byte order mark
+		}
+		m.names[i] = name
+
+		column, ok := lookup[strings.ToLower(name)]
+		if !ok {
+			m.extra = append(m.extra, i)
+			continue
+		}
+		if previous, dup := positions[column]; dup {
+			return nil, fmt.Errorf("columns %q and %q are both %s", header[previous], name, column)
+		}
+		positions[column] = i
+	}
+
+	var missing []string
+	for _, column := range requiredColumns {
+		if _, ok := positions[column]; !ok {
+			names := append([]string(nil), DefaultColumnAliases[column]...)
+			names = append(names, aliases[column]...)
+			missing = append(missing, fmt.Sprintf("%s (accepted names: %s)", column, strings.Join(names, ", ")))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing required column %s", strings.Join(missing, "; "))
+	}
+
+	m.id, m.lat, m.lng, m.timestamp = positions[ColumnID], positions[ColumnLat], positions[ColumnLng], positions[ColumnTimestamp]
+	m.accuracy = -1
+	if i, ok := positions[ColumnAccuracy]; ok {
+		m.accuracy = i
+	}
+	return m, nil
+}
+
+// aliasLookup maps lower-case names from DefaultColumnAliases and aliases to their column
+func aliasLookup(aliases map[string][]string) map[string]string {
+	lookup := make(map[string]string)
+	for _, table := range []map[string][]string{DefaultColumnAliases, aliases} {
+		for column, names := range table {
+			for _, name := range names {
+				lookup[strings.ToLower(name)] = column
+			}
+		}
+	}
+	return lookup
+}
+
+// parse builds a point from one row; extra columns end up in Extra
+func (m *columnMap) parse(record []string) (models.DeliveryPoint, error) {
+	if len(record) != m.width {
+		return models.DeliveryPoint{}, fmt.Errorf("%w: got %d columns, want %d", errRecordLength, len(record), m.width)
+	}
+
+	id, err := strconv.ParseInt(record[m.id], 10, 64)
+	if err != nil {
+		return models.DeliveryPoint{}, err
+	}
+
+	lat, err := strconv.ParseFloat(record[m.lat], 64)
+	if err != nil {
+		return models.DeliveryPoint{}, err
+	}
+
+	lng, err := strconv.ParseFloat(record[m.lng], 64)
+	if err != nil {
+		return models.DeliveryPoint{}, err
+	}
+
+	timestamp, err := utils.ParseTimestampString(record[m.timestamp], m.format)
+	if err != nil {
+		return models.DeliveryPoint{}, err
+	}
+
+	point := models.DeliveryPoint{
+		ID:        id,
+		Latitude:  lat,
+		Longitude: lng,
+		Timestamp: timestamp, // UTC; the tariff applies its own zone
+	}
+
+	if m.accuracy >= 0 && record[m.accuracy] != "" { // An empty value means unknown
+		if point.Accuracy, err = strconv.ParseFloat(record[m.accuracy], 64); err != nil {
+			return models.DeliveryPoint{}, err
+		}
+	}
+
+	if len(m.extra) > 0 {
+		point.Extra = make(map[string]string, len(m.extra))
+		for _, i := range m.extra {
+			point.Extra[m.names[i]] = record[i]
+		}
+	}
+	return point, nil
+}
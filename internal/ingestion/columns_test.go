@@ -0,0 +1,188 @@
+package ingestion
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+func TestNewColumnMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        []string
+		aliases       map[string][]string
+		expected      *columnMap
+		expectedError string
+	}{
+		{
+			name:     "Canonical names",
+			header:   []string{"id_delivery", "lat", "lng", "timestamp"},
+			expected: &columnMap{id: 0, lat: 1, lng: 2, timestamp: 3, accuracy: -1, width: 4},
+		},
+		{
+			name:     "Aliases in another order",
+			header:   []string{"ts", " Latitude ", "LON", "delivery_id"},
+			expected: &columnMap{id: 3, lat: 1, lng: 2, timestamp: 0, accuracy: -1, width: 4},
+		},
+		{
+			name:     "Accuracy and extra columns",
+			header:   []string{"\ufeffid", "courier", "lat", "lng", "timestamp", "accuracy"},
+			expected: &columnMap{id: 0, lat: 2, lng: 3, timestamp: 4, accuracy: 5, width: 6, extra: []int{1}},
+		},
+		{
+			name:     "Custom aliases",
+			header:   []string{"trip", "y", "x", "timestamp"},
+			aliases:  map[string][]string{ColumnID: {"trip"}, ColumnLat: {"y"}, ColumnLng: {"x"}},
+			expected: &columnMap{id: 0, lat: 1, lng: 2, timestamp: 3, accuracy: -1, width: 4},
+		},
+		{
+			name:          "Missing column",
+			header:        []string{"id", "lat", "timestamp"},
+			expectedError: "missing required column lng (accepted names: lng, lon, long, longitude)",
+		},
+		{
+			name:          "Ambiguous columns",
+			header:        []string{"id", "lat", "latitude", "lng", "timestamp"},
+			expectedError: `columns "lat" and "latitude" are both lat`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := newColumnMap(tt.header, tt.aliases, utils.TimestampAuto)
+			if tt.expectedError != "" {
+				if err == nil || err.Error() != tt.expectedError {
+					t.Errorf("Expected error %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			result.names = nil // Covered by TestColumnMapParse
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("newColumnMap(%q) = %+v, want %+v", tt.header, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestColumnMapParse(t *testing.T) {
+	columns, err := newColumnMap([]string{"courier", "timestamp", "lng", "lat", "id", "accuracy"}, nil, utils.TimestampAuto)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		input         []string
+		expected      models.DeliveryPoint
+		expectedError bool
+	}{
+		{
+			name:  "All columns",
+			input: []string{"c-17", "1609459200", "-74.0060", "40.7128", "1", "8.5"},
+			expected: models.DeliveryPoint{
+				ID:        1,
+				Latitude:  40.7128,
+				Longitude: -74.0060,
+				Timestamp: time.Unix(1609459200, 0).UTC(),
+				Accuracy:  8.5,
+				Extra:     map[string]string{"courier": "c-17"},
+			},
+		},
+		{
+			name:  "Unknown accuracy",
+			input: []string{"", "1609459200", "-74.0060", "40.7128", "1", ""},
+			expected: models.DeliveryPoint{
+				ID:        1,
+				Latitude:  40.7128,
+				Longitude: -74.0060,
+				Timestamp: time.Unix(1609459200, 0).UTC(),
+				Extra:     map[string]string{"courier": ""},
+			},
+		},
+		{
+			name:          "Invalid accuracy",
+			input:         []string{"c-17", "1609459200", "-74.0060", "40.7128", "1", "good"},
+			expectedError: true,
+		},
+		{
+			name:          "Too few fields",
+			input:         []string{"c-17", "1609459200", "-74.0060", "40.7128", "1"},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := columns.parse(tt.input)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parse(%q) = %+v, want %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadAndFilterCSVColumnMapping(t *testing.T) {
+	input := `timestamp,courier,latitude,longitude,trip,accuracy
+1609459200,c-17,40.7128,-74.0060,1,5
+1609459260,c-17,40.7129,-74.0061,1,250
+1609459320,c-17,40.7130,-74.0062,1,`
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		ColumnAliases: map[string][]string{ColumnID: {"trip"}},
+		Filters:       FilterChain{AccuracyFilter{MaxRadius: 50}},
+		OnReject:      func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 1 || len(deliveries[0].Points) != 2 {
+		t.Fatalf("Expected 1 delivery with 2 points, got %+v", deliveries)
+	}
+	if deliveries[0].Points[0].Extra["courier"] != "c-17" {
+		t.Errorf("Expected the courier column to be carried along, got %+v", deliveries[0].Points[0])
+	}
+	if len(rejects) != 1 || rejects[0].Line != 3 || rejects[0].Reason != models.RejectLowAccuracy {
+		t.Errorf("Expected a low_accuracy reject on line 3, got %+v", rejects)
+	}
+}
+
+func TestReadAndFilterCSVMissingColumn(t *testing.T) {
+	pointsChan, errChan := ReadAndFilterCSV(writeTempCSV(t, "id,lat,timestamp\n1,40.7128,1609459200\n"))
+	for range pointsChan {
+	}
+	err := <-errChan
+	if !errors.Is(err, ErrUnreadableInput) || !strings.Contains(err.Error(), "missing required column lng") {
+		t.Errorf("Expected a missing column error, got %v", err)
+	}
+}
+
+func TestParseColumnAliases(t *testing.T) {
+	result, err := ParseColumnAliases("id_delivery=trip, lat=y,lat=lat_deg")
+	expected := map[string][]string{ColumnID: {"trip"}, ColumnLat: {"y", "lat_deg"}}
+	if err != nil || !reflect.DeepEqual(result, expected) {
+		t.Errorf("ParseColumnAliases() = %v, %v; want %v", result, err, expected)
+	}
+
+	for _, input := range []string{"trip", "id_delivery=", "speed=v"} {
+		if _, err := ParseColumnAliases(input); err == nil {
+			t.Errorf("ParseColumnAliases(%q): expected an error, but got none", input)
+		}
+	}
+}
@@ -2,118 +2,440 @@ package ingestion
 
 import (
 	"SBCFAA/pkg/utils"
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"math"
-	"os"
-	"strconv"
-	"time"
+	"slices"
+	"strings"
 
 	"SBCFAA/internal/models"
 )
 
-func ReadAndFilterCSV(filename string) (<-chan []models.DeliveryPoint, <-chan error) {
-	pointsChan := make(chan []models.DeliveryPoint, 100)
+// ErrUnreadableInput wraps errors that stopped the input from being read at
+// all (missing file, no header), as opposed to failures part way through
+var ErrUnreadableInput = errors.New("cannot read input")
+
+// errRecordLength is returned for rows whose column count differs from the header's
+var errRecordLength = errors.New("invalid record length")
+
+// Options tunes the ReadAndFilterCSV functions
+type Options struct {
+	// OnReject is called from the reader goroutine for every row that is
+	// dropped. When it is nil, rows that fail to parse are sent on the error
+	// channel instead, which the caller must then drain while reading.
+	OnReject func(models.RejectedRow)
+
+	// Grouping selects how rows are assembled into deliveries. The zero value
+	// is GroupContiguous, which expects each delivery's rows to be adjacent.
+	Grouping GroupingMode
+
+	// SortBufferRows caps the rows GroupExternal holds in memory before
+	// spilling a sorted run to TempDir. Zero means defaultSortBufferRows.
+	SortBufferRows int
+
+	// TempDir is where GroupExternal spills runs; empty means os.TempDir().
+	TempDir string
+
+	// Filters run over each delivery's time-ordered points. Nil means the
+	// DefaultFilterNames chain with default settings; an empty chain keeps
+	// every point.
+	Filters FilterChain
+
+	// ColumnAliases adds header names, per canonical column name, to
+	// DefaultColumnAliases. Columns are located by header name; columns that
+	// match nothing are kept in DeliveryPoint.Extra.
+	ColumnAliases map[string][]string
+
+	// TimestampFormat selects how the timestamp column is read; the zero
+	// value, utils.TimestampAuto, recognises Unix seconds, milliseconds,
+	// microseconds and nanoseconds as well as RFC 3339 strings.
+	TimestampFormat utils.TimestampFormat
+
+	// ParseWorkers, when above 1, parses uncompressed CSV files in
+	// parallel chunks of ChunkSize bytes (zero means defaultChunkSize) on
+	// that many goroutines. Other inputs are always read sequentially.
+	ParseWorkers int
+	ChunkSize    int64
+
+	// Format is the encoding of the inputs. The zero value, FormatAuto,
+	// picks it per file from the extension; unnamed inputs are CSV.
+	Format InputFormat
+
+	// Resume, if set, starts reading at this position, taken from the Next
+	// of a delivery emitted by an earlier run over the same inputs. Rows
+	// before it are skipped unread; rejected rows are counted on from its
+	// Rejects. It cannot be combined with GroupExternal.
+	Resume *models.InputPosition
+
+	// Limits bounds the rows held for any one delivery. Deliveries that
+	// exceed them are split in parts or quarantined, as Limits.Overflow says.
+	Limits DeliveryLimits
+
+	// OnQuarantine is called from the reader goroutine for every delivery
+	// dropped under OverflowQuarantine, once all of its rows have been read
+	OnQuarantine func(models.QuarantinedDelivery)
+
+	// Budget, if set, caps the points in deliveries that have been read but
+	// not yet priced: the reader waits for room before sending each one.
+	Budget *models.PointBudget
+
+	// OnSource, if set, is called from the reader goroutine after each input
+	// has been read. Rejects found by filters may still follow, since a
+	// delivery is filtered only once all of its rows have been read.
+	OnSource func(SourceSummary)
+}
+
+// SourceSummary describes one input once it has been read to the end
+type SourceSummary struct {
+	Name string // empty for ReadAndFilterCSVFrom
+	Rows int64  // data rows, including rejected ones, excluding the header
+}
+
+// row is a parsed input row, with what is needed to report it if a filter
+// drops it later
+type row struct {
+	point   models.DeliveryPoint
+	line    int64
+	ordinal int64 // position among all parsed rows, keeps sorts stable
+	source  int   // index of the input the row came from
+	offset  int64 // where the row starts in its input; -1 when unknown
+	record  []string
+	raw     []byte // the unsplit record: instead of record on the fast path, alongside it for quoted rows
+}
+
+// fields returns the row's record split into columns
+func (r row) fields() []string {
+	if r.record != nil {
+		return r.record
+	}
+	return strings.Split(string(r.raw), ",")
+}
+
+// text returns the row as it was read
+func (r row) text() string {
+	if r.raw != nil {
+		return string(r.raw)
+	}
+	return strings.Join(r.record, ",")
+}
+
+func ReadAndFilterCSV(filename string) (<-chan models.Delivery, <-chan error) {
+	return ReadAndFilterCSVWithOptions(filename, Options{})
+}
+
+// ReadAndFilterCSVWithOptions opens filename with OpenInput and reads it like
+// ReadAndFilterCSVFrom, without a context
+func ReadAndFilterCSVWithOptions(filename string, opts Options) (<-chan models.Delivery, <-chan error) {
+	return ReadAndFilterCSVFiles(context.Background(), []string{filename}, opts)
+}
+
+// ReadAndFilterCSVFiles reads the files in order as if they were one input,
+// so a delivery whose rows continue in the next file is still priced once.
+// Each file is opened with OpenInput, so StdinInput reads standard input,
+// and read in opts.Format; CSV files must each have their own header row.
+// Rejected rows carry the name of their file.
+func ReadAndFilterCSVFiles(ctx context.Context, filenames []string, opts Options) (<-chan models.Delivery, <-chan error) {
+	sources := make([]source, len(filenames))
+	for i, filename := range filenames {
+		filename := filename
+		sources[i] = source{name: filename, file: filename != StdinInput, open: func() (io.ReadCloser, error) { return OpenInput(filename) }}
+	}
+	return readSources(ctx, sources, opts)
+}
+
+// ReadAndFilterCSVFrom groups the CSV rows read from r into deliveries and
+// runs opts.Filters over each of them. The error channel carries at most one
+// error, the one that stopped reading; when ctx is cancelled reading stops
+// and that error is ctx.Err(). The caller keeps ownership of r.
+func ReadAndFilterCSVFrom(ctx context.Context, r io.Reader, opts Options) (<-chan models.Delivery, <-chan error) {
+	return readSources(ctx, []source{{open: func() (io.ReadCloser, error) { return io.NopCloser(r), nil }}}, opts)
+}
+
+// source is one input of a run; name is empty for a bare io.Reader
+type source struct {
+	name string
+	file bool // name is a path that can be read in chunks
+	open func() (io.ReadCloser, error)
+}
+
+// readSources starts the reader goroutine
+func readSources(ctx context.Context, sources []source, opts Options) (<-chan models.Delivery, <-chan error) {
+	pointsChan := make(chan models.Delivery, 100)
 	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(pointsChan)
 		defer close(errChan)
-
-		file, err := os.Open(filename)
-		if err != nil {
+		if err := readCSV(ctx, sources, opts, pointsChan, errChan); err != nil {
 			errChan <- err
-			return
 		}
-		defer file.Close()
+	}()
 
-		reader := csv.NewReader(bufio.NewReader(file))
-		if _, err := reader.Read(); err != nil { // Skip header
-			errChan <- err
-			return
-		}
+	return pointsChan, errChan
+}
 
-		var currentDelivery []models.DeliveryPoint
-		var currentID int64 = -1
+// readCSV is the body of the reader goroutine. It returns the error that
+// stopped reading; errChan is only used directly for legacy parse errors.
+func readCSV(ctx context.Context, sources []source, opts Options, pointsChan chan<- models.Delivery, errChan chan<- error) error {
+	done := ctx.Done()
 
-		sendDelivery := func() {
-			if len(currentDelivery) > 0 {
-				pointsChan <- currentDelivery
-				currentDelivery = nil
-			}
+	var start models.InputPosition
+	if opts.Resume != nil {
+		if opts.Grouping == GroupExternal {
+			return errors.New("cannot resume with external grouping")
 		}
+		start = *opts.Resume
+	}
 
-		for {
-			record, err := reader.Read()
-			if err == io.EOF {
-				sendDelivery() // Send the last group
-				break
-			}
-			if err != nil {
-				errChan <- err
-				return
+	rejected := start.Rejects
+	reject := func(row models.RejectedRow) {
+		rejected++
+		if opts.OnReject != nil {
+			opts.OnReject(row)
+		} else if row.Reason == models.RejectCSVSyntax || row.Reason == models.RejectColumnCount || row.Reason == models.RejectParseError {
+			select {
+			case errChan <- fmt.Errorf("error parsing record: %v", row.Detail):
+			case <-done:
 			}
+		}
+	}
+	rejectRow := func(r row, reason models.RejectReason, detail string) {
+		reject(models.RejectedRow{File: sources[r.source].name, Line: r.line, Reason: reason, Detail: detail, Record: r.fields(), Raw: r.text()})
+	}
 
-			point, err := parseDeliveryPoint(record)
-			if err != nil {
-				errChan <- fmt.Errorf("error parsing record: %v", err)
-				continue
-			}
+	filters := opts.Filters
+	if filters == nil {
+		filters, _ = NewFilterChain(DefaultFilterNames, FilterParams{}) // The defaults always build
+	}
 
-			if point.ID != currentID {
-				sendDelivery() // Send the previous group
-				currentDelivery = []models.DeliveryPoint{point}
-				currentID = point.ID
-			} else {
-				if len(currentDelivery) > 0 {
-					prevPoint := currentDelivery[len(currentDelivery)-1]
-					speed := calculateSpeed(prevPoint, point)
-					if speed <= 100 { // 100 km/h filter
-						currentDelivery = append(currentDelivery, point)
-					}
-				} else {
-					currentDelivery = append(currentDelivery, point)
-				}
+	quarantined := start.Quarantined
+	quarantine := func(delivery models.QuarantinedDelivery, source int) {
+		quarantined++
+		delivery.File = sources[source].name
+		if opts.OnQuarantine != nil {
+			opts.OnQuarantine(delivery)
+		}
+	}
+
+	// A contiguous group is emitted when the first row of the next one is
+	// added, so reading resumes at that row. External grouping emits only
+	// once everything is read, in another order, so it cannot resume, and
+	// nor can a delivery that is split in parts until its last part.
+	var next models.InputPosition
+	var seq int64
+	var part int                    // parts of the current delivery sent so far
+	var carry *models.DeliveryPoint // last point of the previous part
+	group := newGrouper(opts, func(rows []row, more bool) error {
+		points := filterDelivery(rows, filters, rejectRow)
+		if carry != nil { // So the segment across the cut is priced
+			points = append([]models.DeliveryPoint{*carry}, points...)
+		}
+		if len(points) == 0 {
+			return nil
+		}
+
+		delivery := models.Delivery{Seq: seq, Points: points, Next: next, Budget: opts.Budget}
+		delivery.Next.Rejects, delivery.Next.Quarantined = rejected, quarantined
+		if more || part > 0 {
+			part++
+			delivery.Part, delivery.More = part, more
+		}
+		if more {
+			last := points[len(points)-1]
+			carry = &last
+			delivery.Next.Offset = -1
+		} else {
+			part, carry = 0, nil
+		}
+		if opts.Grouping == GroupExternal {
+			delivery.Next.Offset = -1
+		}
+
+		if err := opts.Budget.Acquire(ctx, len(points)); err != nil {
+			return err
+		}
+		select {
+		case pointsChan <- delivery:
+		case <-done:
+			opts.Budget.Release(len(points))
+			return ctx.Err()
+		}
+		seq++
+		return nil
+	}, quarantine)
+	defer group.close()
+	tracked := &trackingGrouper{grouper: group, next: &next}
+
+	var ordinal int64 // Counts across sources, so external sorts keep file order
+	for index := start.Source; index < len(sources); index++ {
+		src := sources[index]
+		sourceReject := func(row models.RejectedRow) {
+			row.File = src.name
+			reject(row)
+		}
+		var resume *models.InputPosition
+		if opts.Resume != nil && index == start.Source {
+			resume = &start
+		}
+		rows, err := readOneSource(ctx, src, opts, index, resume, &ordinal, tracked, sourceReject)
+		if err != nil {
+			if src.name != "" && !errors.Is(err, ctx.Err()) {
+				err = fmt.Errorf("%s: %w", src.name, err)
 			}
+			return err
 		}
-	}()
+		if opts.OnSource != nil {
+			opts.OnSource(SourceSummary{Name: src.name, Rows: rows})
+		}
+	}
 
-	return pointsChan, errChan
+	next = models.InputPosition{Source: len(sources)} // Everything has been read
+	return group.flush()                              // Send the last group(s)
 }
 
-func parseDeliveryPoint(record []string) (models.DeliveryPoint, error) {
-	if len(record) != 4 {
-		return models.DeliveryPoint{}, fmt.Errorf("invalid record length")
+// trackingGrouper records where each row it adds starts, which is where
+// reading resumes after any group the add emits
+type trackingGrouper struct {
+	grouper
+	next *models.InputPosition
+}
+
+func (g *trackingGrouper) add(r row) error {
+	*g.next = models.InputPosition{Source: r.source, Offset: r.offset, Line: r.line}
+	return g.grouper.add(r)
+}
+
+// readOneSource picks the chunked or the sequential reader for src. When
+// resume is set, reading starts at that row rather than after the header.
+func readOneSource(ctx context.Context, src source, opts Options, index int, resume *models.InputPosition, ordinal *int64, group grouper, reject func(models.RejectedRow)) (int64, error) {
+	format := opts.Format.resolve(src.name)
+	if opts.ParseWorkers > 1 && src.file && format == FormatCSV {
+		if rows, ok, err := readFileChunked(ctx, src.name, opts, index, resume, ordinal, group, reject); ok {
+			return rows, err
+		}
 	}
 
-	id, err := strconv.ParseInt(record[0], 10, 64)
+	input, err := src.open()
 	if err != nil {
-		return models.DeliveryPoint{}, err
+		return 0, err
+	}
+	defer input.Close()
+	return readSource(ctx, input, format, opts, index, resume, ordinal, group, reject)
+}
+
+// readSource feeds the rows of one source to group and returns how many data
+// rows it had
+func readSource(ctx context.Context, r io.Reader, format InputFormat, opts Options, index int, resume *models.InputPosition, ordinal *int64, group grouper, reject func(models.RejectedRow)) (int64, error) {
+	done := ctx.Done()
+
+	reader, err := newRowReader(format, r, opts)
+	if err != nil {
+		return 0, err
+	}
+	if resume != nil {
+		resumable, ok := reader.(resumableReader)
+		if !ok {
+			return 0, errors.New("cannot resume part way through this input format")
+		}
+		if err := resumable.skipTo(resume.Offset, resume.Line); err != nil {
+			return 0, err
+		}
+	}
+
+	var rows int64
+	for {
+		select {
+		case <-done:
+			return rows, ctx.Err()
+		default:
+		}
+
+		parsed, rejected, err := reader.next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows++
+		if rejected != nil {
+			reject(*rejected)
+			continue
+		}
+
+		parsed.ordinal, parsed.source = *ordinal, index
+		if err := group.add(parsed); err != nil {
+			return rows, err
+		}
+		*ordinal++
 	}
+}
+
+// csvRowReader is the rowReader for CSV input
+type csvRowReader struct {
+	records *recordReader
+	columns *columnMap
+}
 
-	lat, err := strconv.ParseFloat(record[1], 64)
+func newCSVRowReader(r io.Reader, opts Options) (*csvRowReader, error) {
+	records := newRecordReader(r)
+	columns, err := readHeader(records, opts)
 	if err != nil {
-		return models.DeliveryPoint{}, err
+		return nil, err
 	}
+	return &csvRowReader{records: records, columns: columns}, nil
+}
+
+func (c *csvRowReader) skipTo(offset, line int64) error {
+	return c.records.skipTo(offset, line)
+}
 
-	lng, err := strconv.ParseFloat(record[2], 64)
+func (c *csvRowReader) next() (row, *models.RejectedRow, error) {
+	record, line, quoted, err := c.records.next()
 	if err != nil {
-		return models.DeliveryPoint{}, err
+		return row{}, nil, err
 	}
+	parsed, rejected := parseRecord(record, line, quoted, c.columns)
+	parsed.offset = c.records.start
+	return parsed, rejected, nil
+}
 
-	timestamp, err := strconv.ParseInt(record[3], 10, 64)
+// readHeader reads the header row and locates the columns
+func readHeader(records *recordReader, opts Options) (*columnMap, error) {
+	record, _, _, err := records.next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrUnreadableInput, err)
+	}
+	reader := csv.NewReader(bytes.NewReader(record))
+	// Column count is checked per row by columnMap.parse
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
 	if err != nil {
-		return models.DeliveryPoint{}, err
+		return nil, fmt.Errorf("%w: reading header: %v", ErrUnreadableInput, err)
 	}
+	columns, err := newColumnMap(header, opts.ColumnAliases, opts.TimestampFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreadableInput, err)
+	}
+	return columns, nil
+}
 
-	return models.DeliveryPoint{
-		ID:        id,
-		Latitude:  lat,
-		Longitude: lng,
-		Timestamp: time.Unix(timestamp, 0),
-	}, nil
+// filterDelivery orders a delivery's rows by timestamp and runs the filter chain over them
+func filterDelivery(rows []row, filters FilterChain, reject func(r row, reason models.RejectReason, detail string)) []models.DeliveryPoint {
+	byTime := func(a, b row) int { return a.point.Timestamp.Compare(b.point.Timestamp) }
+	if !slices.IsSortedFunc(rows, byTime) {
+		slices.SortStableFunc(rows, byTime)
+	}
+	return filters.apply(rows, reject)
+}
+
+// parseDeliveryPoint parses a row in the fixed id,lat,lng,timestamp layout
+func parseDeliveryPoint(record []string) (models.DeliveryPoint, error) {
+	return positionalColumns.parse(record)
 }
 
 func calculateSpeed(p1, p2 models.DeliveryPoint) float64 {
@@ -2,9 +2,20 @@ package ingestion
 
 import (
 	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -116,9 +127,14 @@ func TestReadAndFilterCSVEndToEnd(t *testing.T) {
 
 	// Process the results
 	deliveries := make(map[int64][]models.DeliveryPoint)
-	for points := range pointsChan {
-		if len(points) > 0 {
-			deliveries[points[0].ID] = points
+	var expectedSeq int64
+	for delivery := range pointsChan {
+		if delivery.Seq != expectedSeq {
+			t.Errorf("Expected Seq %d, got %d", expectedSeq, delivery.Seq)
+		}
+		expectedSeq++
+		if len(delivery.Points) > 0 {
+			deliveries[delivery.Points[0].ID] = delivery.Points
 		}
 	}
 
@@ -152,6 +168,123 @@ func TestReadAndFilterCSVEndToEnd(t *testing.T) {
 	}
 }
 
+func TestReadAndFilterCSVRejects(t *testing.T) {
+	input := `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+1,invalid,-74.0061,1609459260
+1,40.7130,-74.0062
+1,50.7133,-84.0065,1609459320
+1,40.7131,-74.0063,16094"59380
+2,40.7132,-74.0064,1609459440
+2,40.7133,-74.0065,1609459500`
+
+	tmpfile, err := ioutil.TempFile("", "test_rejects.csv")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(input)); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(tmpfile.Name(), Options{
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+
+	pointCount := 0
+	for delivery := range pointsChan {
+		pointCount += len(delivery.Points)
+	}
+	for err := range errChan {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if pointCount != 3 {
+		t.Errorf("Expected 3 points, got %d", pointCount)
+	}
+
+	expected := []struct {
+		line   int64
+		reason models.RejectReason
+	}{
+		{3, models.RejectParseError},
+		{4, models.RejectColumnCount},
+		{5, models.RejectSpeedFilter},
+		{6, models.RejectCSVSyntax},
+	}
+	if len(rejects) != len(expected) {
+		t.Fatalf("Expected %d rejects, got %d: %+v", len(expected), len(rejects), rejects)
+	}
+	// Filter rejects are reported when their delivery is complete
+	sort.Slice(rejects, func(i, j int) bool { return rejects[i].Line < rejects[j].Line })
+	for i, e := range expected {
+		if rejects[i].Line != e.line || rejects[i].Reason != e.reason {
+			t.Errorf("Reject %d: got line %d reason %s, want line %d reason %s", i, rejects[i].Line, rejects[i].Reason, e.line, e.reason)
+		}
+		if rejects[i].Detail == "" {
+			t.Errorf("Reject %d has no detail", i)
+		}
+	}
+	if rejects[0].Record[1] != "invalid" {
+		t.Errorf("Expected the raw record to be kept, got %v", rejects[0].Record)
+	}
+}
+
+func TestReadAndFilterCSVRejectsRawRecord(t *testing.T) {
+	input := "id_delivery,lat,lng,timestamp\n" +
+		"1,35.7000,51.4000,1609459200\n" +
+		"1,\"35,7\",51.4,1609459260\n" + // One field with a comma, not five
+		"1,\"35.7000\",51.4000,1609459200\n" + // Parses, then dropped as a duplicate
+		"1,35.7,51.4,\"unterminated\n"
+	path := filepath.Join(t.TempDir(), "raw.csv")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+
+	expected := map[models.RejectReason]string{
+		models.RejectParseError: `1,"35,7",51.4,1609459260`,
+		models.RejectDuplicate:  `1,"35.7000",51.4000,1609459200`,
+		models.RejectCSVSyntax:  `1,35.7,51.4,"unterminated`,
+	}
+	for name, opts := range map[string]Options{
+		"sequential": {},
+		"chunked":    {ParseWorkers: 2, ChunkSize: 16},
+		"external":   {Grouping: GroupExternal, SortBufferRows: 1, TempDir: t.TempDir()},
+	} {
+		_, rejects, _ := readAll(t, []string{path}, opts)
+		if len(rejects) != len(expected) {
+			t.Fatalf("%s: expected %d rejects, got %+v", name, len(expected), rejects)
+		}
+		for _, reject := range rejects {
+			if reject.Raw != expected[reject.Reason] {
+				t.Errorf("%s: %s reject has raw record %q, want %q", name, reject.Reason, reject.Raw, expected[reject.Reason])
+			}
+		}
+	}
+}
+
+func TestReadAndFilterCSVUnreadableInput(t *testing.T) {
+	emptyFile, err := ioutil.TempFile("", "test_empty.csv")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	emptyFile.Close()
+	defer os.Remove(emptyFile.Name())
+
+	for _, filename := range []string{emptyFile.Name() + ".missing", emptyFile.Name()} {
+		pointsChan, errChan := ReadAndFilterCSV(filename)
+		for range pointsChan {
+		}
+		if err := <-errChan; !errors.Is(err, ErrUnreadableInput) {
+			t.Errorf("%s: expected ErrUnreadableInput, got %v", filename, err)
+		}
+	}
+}
+
 func TestParseDeliveryPoint(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -324,3 +457,431 @@ func TestCalculateSpeed(t *testing.T) {
 		})
 	}
 }
+
+func TestReadAndFilterCSVOrdersAndDeduplicates(t *testing.T) {
+	input := `id,lat,lng,timestamp
+1,40.7130,-74.0062,1609459320
+1,40.7128,-74.0060,1609459200
+1,40.7129,-74.0061,1609459260
+1,40.7129,-74.0061,1609459260
+1,40.7500,-74.0061,1609459260
+1,40.7131,-74.0063,1609459380`
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+	}
+	points := deliveries[0].Points
+	expectedLatitudes := []float64{40.7128, 40.7129, 40.7130, 40.7131}
+	if len(points) != len(expectedLatitudes) {
+		t.Fatalf("Expected %d points, got %d", len(expectedLatitudes), len(points))
+	}
+	for i, lat := range expectedLatitudes {
+		if points[i].Latitude != lat {
+			t.Errorf("Point %d: expected latitude %v, got %v", i, lat, points[i].Latitude)
+		}
+		if i > 0 && !points[i].Timestamp.After(points[i-1].Timestamp) {
+			t.Errorf("Point %d is not after point %d", i, i-1)
+		}
+	}
+
+	if len(rejects) != 2 {
+		t.Fatalf("Expected 2 rejects, got %+v", rejects)
+	}
+	if rejects[0].Line != 5 || rejects[0].Reason != models.RejectDuplicate {
+		t.Errorf("Expected a duplicate on line 5, got %+v", rejects[0])
+	}
+	if rejects[1].Line != 6 || rejects[1].Reason != models.RejectTimeConflict {
+		t.Errorf("Expected a timestamp_conflict on line 6, got %+v", rejects[1])
+	}
+}
+
+func TestReadAndFilterCSVFrom(t *testing.T) {
+	input := `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+1,40.7129,-74.0061,1609459260
+2,40.7130,-74.0062,1609459320`
+
+	pointsChan, errChan := ReadAndFilterCSVFrom(context.Background(), strings.NewReader(input), Options{})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 2 || len(deliveries[0].Points) != 2 || len(deliveries[1].Points) != 1 {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+}
+
+// endlessCSV produces a header followed by one-row deliveries forever
+type endlessCSV struct {
+	pending []byte
+	id      int
+}
+
+func (r *endlessCSV) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.id == 0 {
+			r.pending = []byte("id,lat,lng,timestamp\n")
+		} else {
+			r.pending = []byte(fmt.Sprintf("%d,40.7128,-74.0060,1609459200\n", r.id))
+		}
+		r.id++
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func TestReadAndFilterCSVFromCancel(t *testing.T) {
+	for _, grouping := range []GroupingMode{GroupContiguous, GroupExternal} {
+		ctx, cancel := context.WithCancel(context.Background())
+		pointsChan, errChan := ReadAndFilterCSVFrom(ctx, &endlessCSV{}, Options{
+			Grouping:       grouping,
+			SortBufferRows: 100,
+			TempDir:        t.TempDir(),
+		})
+
+		if grouping == GroupContiguous {
+			<-pointsChan // Reading has started
+		}
+		cancel()
+
+		for range pointsChan { // Must be closed despite the endless input
+		}
+		if err := <-errChan; !errors.Is(err, context.Canceled) {
+			t.Errorf("grouping %v: expected context.Canceled, got %v", grouping, err)
+		}
+	}
+}
+
+func TestReadAndFilterCSVFiles(t *testing.T) {
+	dir := t.TempDir()
+	day1 := filepath.Join(dir, "day1.csv")
+	day2 := filepath.Join(dir, "day2.csv")
+	// Delivery 2 continues in day2.csv; its repeated point is reported there
+	files := map[string]string{
+		day1: `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+1,40.7129,-74.0061,1609459260
+2,40.7130,-74.0062,1609459320`,
+		day2: `id,lat,lng,timestamp
+2,40.7130,-74.0062,1609459320
+2,40.7131,-74.0063,1609459380
+3,40.7140,-74.0070,1609459400`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+	}
+
+	for _, grouping := range []GroupingMode{GroupContiguous, GroupExternal} {
+		var rejects []models.RejectedRow
+		var summaries []SourceSummary
+		pointsChan, errChan := ReadAndFilterCSVFiles(context.Background(), []string{day1, day2}, Options{
+			Grouping: grouping,
+			TempDir:  t.TempDir(),
+			OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+			OnSource: func(summary SourceSummary) { summaries = append(summaries, summary) },
+		})
+		deliveries := collectDeliveries(t, pointsChan, errChan)
+
+		if len(deliveries) != 3 || len(deliveries[1].Points) != 2 {
+			t.Errorf("grouping %v: expected delivery 2 to be merged across files, got %+v", grouping, deliveries)
+		}
+		if len(rejects) != 1 || rejects[0].File != day2 || rejects[0].Line != 2 || rejects[0].Reason != models.RejectDuplicate {
+			t.Errorf("grouping %v: expected a duplicate on line 2 of day2.csv, got %+v", grouping, rejects)
+		}
+		expected := []SourceSummary{{Name: day1, Rows: 3}, {Name: day2, Rows: 3}}
+		if !reflect.DeepEqual(summaries, expected) {
+			t.Errorf("grouping %v: summaries %+v, want %+v", grouping, summaries, expected)
+		}
+	}
+}
+
+func TestReadAndFilterCSVFilesUnreadable(t *testing.T) {
+	good := writeTempCSV(t, "id,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200\n")
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+
+	pointsChan, errChan := ReadAndFilterCSVFiles(context.Background(), []string{good, missing}, Options{})
+	for range pointsChan {
+	}
+	if err := <-errChan; !errors.Is(err, ErrUnreadableInput) {
+		t.Errorf("Expected ErrUnreadableInput, got %v", err)
+	}
+}
+
+func TestReadAndFilterCSVResume(t *testing.T) {
+	ndjson := writeTempInput(t, "trips.ndjson", `{"id_delivery": 7, "lat": 35.7, "lng": 51.4, "timestamp": 1609459200}
+{"id_delivery": 7, "lat": 35.7054, "lng": 51.4, "timestamp": 1609459260}
+
+{"id_delivery": 8, "lat": "bad", "lng": 51.4, "timestamp": 1609459200}
+{"id_delivery": 8, "lat": 35.7, "lng": 51.4, "timestamp": 1609459200}
+{"id_delivery": 9, "lat": 35.7, "lng": 51.4, "timestamp": 1609459200}
+`)
+	paths := []string{generateCSV(t, 12, 5, 7), ndjson, generateCSV(t, 3, 4, 0)}
+
+	for _, workers := range []int{1, 3} {
+		opts := Options{ParseWorkers: workers, ChunkSize: 64}
+		expected, expectedRejects, _ := readAll(t, paths, opts)
+
+		// Resuming after any delivery reads exactly the ones after it
+		for i, delivery := range expected {
+			if delivery.Next.Offset < 0 {
+				t.Fatalf("%d workers: delivery %d has no resume offset", workers, i)
+			}
+			opts.Resume = &delivery.Next
+			deliveries, rejects, _ := readAll(t, paths, opts)
+			if !reflect.DeepEqual(deliveryPoints(deliveries), deliveryPoints(expected[i+1:])) {
+				t.Errorf("%d workers: resuming after delivery %d read %d deliveries, want %d", workers, i, len(deliveries), len(expected)-i-1)
+			}
+			if len(deliveries) > 0 && deliveries[len(deliveries)-1].Next != expected[len(expected)-1].Next {
+				t.Errorf("%d workers: resuming after delivery %d ended at %+v, want %+v", workers, i, deliveries[len(deliveries)-1].Next, expected[len(expected)-1].Next)
+			}
+			if want := expectedRejects[delivery.Next.Rejects:]; len(rejects) != len(want) || len(want) > 0 && !reflect.DeepEqual(rejects, want) {
+				t.Errorf("%d workers: resuming after delivery %d rejected %+v, want %+v", workers, i, rejects, want)
+			}
+		}
+	}
+}
+
+func TestReadAndFilterCSVResumeUnsupported(t *testing.T) {
+	gpx := writeTempInput(t, "trips.gpx", `<gpx><trk><number>1</number><trkseg><trkpt lat="35.7" lon="51.4"><time>2021-01-01T00:00:00Z</time></trkpt></trkseg></trk></gpx>`)
+	csvFile := writeTempCSV(t, "id,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200\n")
+
+	tests := []struct {
+		name  string
+		paths []string
+		opts  Options
+	}{
+		{"External grouping", []string{csvFile}, Options{Grouping: GroupExternal, Resume: &models.InputPosition{Offset: 25, Line: 2}}},
+		{"Inside a GPX file", []string{gpx}, Options{Resume: &models.InputPosition{Offset: 100, Line: 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pointsChan, errChan := ReadAndFilterCSVFiles(context.Background(), tt.paths, tt.opts)
+			for range pointsChan {
+			}
+			if err := <-errChan; err == nil {
+				t.Errorf("Expected an error, got none")
+			}
+		})
+	}
+}
+
+func TestReadAndFilterCSVSubSecondTimestamps(t *testing.T) {
+	// Pings every 0.5 s, ~5.6 m apart: 40 km/h. Truncated to whole seconds,
+	// every other ping would look like a duplicate timestamp.
+	input := `id,lat,lng,timestamp
+1,40.712800,-74.0060,1609459200000
+1,40.712850,-74.0060,1609459200500
+1,40.712900,-74.0060,1609459201000
+1,40.712950,-74.0060,1609459201500`
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 1 || len(deliveries[0].Points) != 4 || len(rejects) != 0 {
+		t.Fatalf("Expected 1 delivery with 4 points and no rejects, got %+v, %+v", deliveries, rejects)
+	}
+	if gap := deliveries[0].Points[1].Timestamp.Sub(deliveries[0].Points[0].Timestamp); gap != 500*time.Millisecond {
+		t.Errorf("Expected pings 500ms apart, got %v", gap)
+	}
+}
+
+// parsedRecord is what a reader made of one data row
+type parsedRecord struct {
+	point  models.DeliveryPoint
+	line   int64
+	reason models.RejectReason
+	fields []string
+}
+
+// readRecordsCSV parses input the way the reader did before the fast path:
+// encoding/csv, then columnMap.parse
+func readRecordsCSV(tb testing.TB, input string) []parsedRecord {
+	reader := csv.NewReader(strings.NewReader(input))
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		tb.Fatal(err)
+	}
+	var records []parsedRecord
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			records = append(records, parsedRecord{line: int64(parseErr.StartLine), reason: models.RejectCSVSyntax, fields: record})
+			continue
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+		line, _ := reader.FieldPos(0)
+		point, err := positionalColumns.parse(record)
+		switch {
+		case errors.Is(err, errRecordLength):
+			records = append(records, parsedRecord{line: int64(line), reason: models.RejectColumnCount, fields: record})
+		case err != nil:
+			records = append(records, parsedRecord{line: int64(line), reason: models.RejectParseError, fields: record})
+		default:
+			records = append(records, parsedRecord{point: point, line: int64(line), fields: record})
+		}
+	}
+}
+
+// readRecordsFast parses input with recordReader and parseRecord
+func readRecordsFast(tb testing.TB, input string) []parsedRecord {
+	records := newRecordReader(strings.NewReader(input))
+	columns, err := readHeader(records, Options{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var parsed []parsedRecord
+	for {
+		record, line, quoted, err := records.next()
+		if err == io.EOF {
+			return parsed
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+		r, rejected := parseRecord(record, line, quoted, columns)
+		if rejected != nil {
+			parsed = append(parsed, parsedRecord{line: rejected.Line, reason: rejected.Reason, fields: rejected.Record})
+			continue
+		}
+		parsed = append(parsed, parsedRecord{point: r.point, line: r.line, fields: r.fields()})
+	}
+}
+
+func TestFastPathMatchesEncodingCSV(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{"plain", "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200\n1,40.7129,-74.0061,1609459260\n"},
+		{"no final line break", "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200"},
+		{"CRLF", "id_delivery,lat,lng,timestamp\r\n1,40.7128,-74.0060,1609459200\r\n2,40.7129,-74.0061,1609459260\r\n"},
+		{"blank lines", "id_delivery,lat,lng,timestamp\n\n1,40.7128,-74.0060,1609459200\n\r\n\n2,40.7129,-74.0061,1609459260\n"},
+		{"quoted fields", "id_delivery,lat,lng,timestamp\n\"1\",\"40.7128\",-74.0060,1609459200\n"},
+		{"quoted header", "\"id_delivery\",\"lat\",\"lng\",\"timestamp\"\n1,40.7128,-74.0060,1609459200\n"},
+		{"line break in quotes", "id_delivery,lat,lng,timestamp\n1,\"40.7128\n\",-74.0060,1609459200\n2,40.7129,-74.0061,1609459260\n"},
+		{"escaped quote", "id_delivery,lat,lng,timestamp\n1,\"4\"\"0\",-74.0060,1609459200\n2,40.7129,-74.0061,1609459260\n"},
+		{"bare quote", "id_delivery,lat,lng,timestamp\n1,40\"7,-74.0060,1609459200\n2,40.7129,-74.0061,1609459260\n"},
+		{"unterminated quote", "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200\n2,\"40.7129,-74.0061,1609459260\n"},
+		{"bad values", "id_delivery,lat,lng,timestamp\nx,40.7128,-74.0060,1609459200\n1,,-74.0060,1609459200\n1,40.7128,-74.0060,soon\n"},
+		{"column count", "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060\n1,40.7128,-74.0060,1609459200,5\n"},
+		{"timestamp formats", "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200123\n1,40.7128,-74.0060,1609459200.5\n1,40.7128,-74.0060,2021-01-01T00:00:00Z\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := readRecordsCSV(t, tc.input)
+			got := readRecordsFast(t, tc.input)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("fast path = %+v\nencoding/csv = %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRecordReaderLongLines(t *testing.T) {
+	long := strings.Repeat("x", 200<<10)
+	input := "id_delivery,lat,lng,timestamp\n1,40.7128,-74.0060,1609459200\n2,\"" + long + "\n" + long + "\",-74.0060,1609459200\n3,40.7128,-74.0060,1609459200\n"
+	got := readRecordsFast(t, input)
+	if want := readRecordsCSV(t, input); !reflect.DeepEqual(got, want) {
+		t.Fatalf("fast path and encoding/csv disagree on a %d byte record", 2*len(long))
+	}
+	if len(got) != 3 || got[2].line != 5 {
+		t.Errorf("got %d records, last on line %d; want 3, line 5", len(got), got[len(got)-1].line)
+	}
+}
+
+func TestParseFixedRecordAllocations(t *testing.T) {
+	record := []byte("12345,35.7154,51.4000,1609459200")
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, ok := parseFixedRecord(record, utils.TimestampAuto); !ok {
+			t.Fatal("parseFixedRecord failed")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("parseFixedRecord allocates %v times per row, want 0", allocs)
+	}
+}
+
+// BenchmarkParseRecords compares the fast path with encoding/csv plus
+// columnMap.parse over the same input, without grouping or filtering
+func BenchmarkParseRecords(b *testing.B) {
+	data, err := os.ReadFile(generateCSV(b, 2000, 25, 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			records := newRecordReader(bytes.NewReader(data))
+			columns, err := readHeader(records, Options{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for {
+				record, line, quoted, err := records.next()
+				if err == io.EOF {
+					break
+				}
+				if _, rejected := parseRecord(record, line, quoted, columns); rejected != nil {
+					b.Fatal(rejected.Detail)
+				}
+			}
+		}
+	})
+
+	b.Run("encoding/csv", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			reader := csv.NewReader(bytes.NewReader(data))
+			reader.FieldsPerRecord = -1
+			reader.Read() // Header
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if _, err := positionalColumns.parse(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkReadSource measures sequential reading including grouping, the
+// filter chain and building each delivery's points
+func BenchmarkReadSource(b *testing.B) {
+	data, err := os.ReadFile(generateCSV(b, 2000, 25, 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		pointsChan, errChan := ReadAndFilterCSVFrom(context.Background(), bytes.NewReader(data), Options{})
+		for range pointsChan {
+		}
+		if err := <-errChan; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a stream compression format recognised in input files
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionBzip2
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// DetectCompression identifies the format from the first bytes of a stream,
+// falling back to the extension of name when they are not conclusive
+func DetectCompression(head []byte, name string) Compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(head, bzip2Magic):
+		return CompressionBzip2
+	}
+	if len(head) >= len(zstdMagic) { // Enough bytes to rule out every format
+		return CompressionNone
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	case ".bz2":
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+// OpenInput opens filename, decompressing it on the fly if needed. StdinInput
+// reads standard input, which closing the result leaves open. Errors wrap
+// ErrUnreadableInput.
+func OpenInput(filename string) (io.ReadCloser, error) {
+	if filename == StdinInput {
+		return Decompress(struct{ io.Reader }{os.Stdin}, "") // Hide Close
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreadableInput, err)
+	}
+	r, err := Decompress(file, filename)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Decompress wraps r in a streaming decompressor chosen by DetectCompression;
+// name is only used for its extension. Closing the result also closes r
+// when r is an io.Closer. Errors wrap ErrUnreadableInput.
+func Decompress(r io.Reader, name string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	head, _ := buffered.Peek(len(zstdMagic)) // Short input is left to the CSV reader
+
+	closer, _ := r.(io.Closer)
+	switch DetectCompression(head, name) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%w: gzip: %v", ErrUnreadableInput, err)
+		}
+		return &decompressor{Reader: gz, close: gz.Close, source: closer}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, fmt.Errorf("%w: zstd: %v", ErrUnreadableInput, err)
+		}
+		return &decompressor{Reader: zr, close: func() error { zr.Close(); return nil }, source: closer}, nil
+	case CompressionBzip2:
+		return &decompressor{Reader: bzip2.NewReader(buffered), source: closer}, nil
+	}
+	seeker, _ := r.(io.ReadSeeker)
+	return &decompressor{Reader: buffered, source: closer, seeker: seeker, buffered: buffered}, nil
+}
+
+// decompressor closes the decoder, then the underlying source
+type decompressor struct {
+	io.Reader
+	close  func() error
+	source io.Closer
+
+	// Uncompressed input from a seekable source can be repositioned
+	seeker   io.ReadSeeker
+	buffered *bufio.Reader
+}
+
+// errNotSeekable is returned when seeking compressed or streamed input
+var errNotSeekable = errors.New("input cannot seek")
+
+// Seek repositions uncompressed input that comes from a seekable source
+func (d *decompressor) Seek(offset int64, whence int) (int64, error) {
+	if d.seeker == nil {
+		return 0, errNotSeekable
+	}
+	position, err := d.seeker.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	d.buffered.Reset(d.seeker)
+	return position, nil
+}
+
+func (d *decompressor) Close() error {
+	var err error
+	if d.close != nil {
+		err = d.close()
+	}
+	if d.source != nil {
+		if sourceErr := d.source.Close(); err == nil {
+			err = sourceErr
+		}
+	}
+	return err
+}
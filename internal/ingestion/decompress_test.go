@@ -0,0 +1,148 @@
+package ingestion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionInput = `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+1,40.7129,-74.0061,1609459260
+2,40.7130,-74.0062,1609459320
+`
+
+// bzip2Input is compressionInput compressed with bzip2 -9; the standard
+// library has no bzip2 writer
+var bzip2Input = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x96, 0x50,
+	0x41, 0x57, 0x00, 0x00, 0x31, 0xd9, 0x80, 0x00, 0x10, 0x00, 0x07, 0x7f,
+	0xe0, 0x26, 0xa7, 0x4c, 0x00, 0x20, 0x00, 0x54, 0x47, 0xaa, 0x26, 0x69,
+	0x1e, 0xa6, 0x26, 0x83, 0x13, 0x0c, 0x93, 0x44, 0xda, 0x8d, 0x3c, 0x88,
+	0x1a, 0x19, 0xa1, 0x78, 0x90, 0x6d, 0x50, 0x26, 0x3e, 0xeb, 0x63, 0x06,
+	0xa0, 0x62, 0xb7, 0x94, 0xca, 0xdc, 0xd0, 0xcc, 0x08, 0x1c, 0x16, 0x2a,
+	0xac, 0xe1, 0xca, 0x28, 0xa3, 0xc6, 0x1b, 0x85, 0x1c, 0x8c, 0x58, 0x82,
+	0x76, 0x40, 0xad, 0xef, 0x8c, 0xda, 0x35, 0x48, 0xe2, 0x52, 0xce, 0xdf,
+	0xae, 0x85, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x25, 0x94, 0x10, 0x55, 0xc0,
+}
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd: %v", err)
+	}
+	defer w.Close()
+	return w.EncodeAll([]byte(data), nil)
+}
+
+func TestReadAndFilterCSVCompressed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"trips.csv", []byte(compressionInput)},
+		{"trips.csv.gz", gzipBytes(t, compressionInput)},
+		{"trips.csv.zst", zstdBytes(t, compressionInput)},
+		{"trips.csv.bz2", bzip2Input},
+		{"misnamed.csv", gzipBytes(t, compressionInput)}, // Magic bytes win over the extension
+		{"plain.csv.gz", []byte(compressionInput)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, tt.content, 0o644); err != nil {
+				t.Fatalf("Failed to write temp file: %v", err)
+			}
+
+			pointsChan, errChan := ReadAndFilterCSV(path)
+			deliveries := collectDeliveries(t, pointsChan, errChan)
+			if len(deliveries) != 2 || len(deliveries[0].Points) != 2 || len(deliveries[1].Points) != 1 {
+				t.Errorf("Unexpected deliveries: %+v", deliveries)
+			}
+		})
+	}
+}
+
+func TestOpenInputCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.csv.gz")
+	if err := os.WriteFile(path, gzipMagic, 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if _, err := OpenInput(path); !errors.Is(err, ErrUnreadableInput) {
+		t.Errorf("Expected ErrUnreadableInput, got %v", err)
+	}
+}
+
+func TestOpenInputStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin; r.Close() }()
+
+	compressed := gzipBytes(t, compressionInput)
+	go func() {
+		w.Write(compressed)
+		w.Close()
+	}()
+	input, err := OpenInput(StdinInput)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := io.ReadAll(input)
+	if err != nil || string(data) != compressionInput {
+		t.Errorf("Expected the decompressed input, got %q, %v", data, err)
+	}
+	if err := input.Close(); err != nil {
+		t.Errorf("Unexpected error closing: %v", err)
+	}
+	if _, err := r.Stat(); err != nil {
+		t.Errorf("Expected standard input to stay open, got %v", err)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		head     []byte
+		name     string
+		expected Compression
+	}{
+		{[]byte("id,lat"), "in.csv", CompressionNone},
+		{[]byte("id,lat"), "in.csv.gz", CompressionNone},
+		{[]byte{0x1f, 0x8b, 0x08, 0x00}, "in.csv", CompressionGzip},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "in", CompressionZstd},
+		{[]byte("BZh9"), "in", CompressionBzip2},
+		{nil, "in.csv.ZST", CompressionZstd},
+		{[]byte{0x1f}, "in.bz2", CompressionBzip2},
+		{nil, "in.csv", CompressionNone},
+	}
+
+	for _, tt := range tests {
+		if result := DetectCompression(tt.head, tt.name); result != tt.expected {
+			t.Errorf("DetectCompression(%v, %q) = %v, want %v", tt.head, tt.name, result, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+// recordReader splits CSV input into records at line breaks, handing out
+// slices of its read buffer. Records with a quoted field spanning lines are
+// joined into a scratch buffer. Empty lines are skipped, as encoding/csv does.
+type recordReader struct {
+	source io.Reader
+	reader *bufio.Reader
+	line   int64  // lines consumed so far
+	offset int64  // bytes consumed so far
+	start  int64  // offset of the record last returned
+	long   []byte // a line that did not fit the read buffer
+	joined []byte // a record spanning several lines
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{source: r, reader: bufio.NewReaderSize(r, 64<<10)}
+}
+
+// next returns the next record without its line break, the line it starts
+// on, and whether it contains a quote. The slice is only valid until the
+// next call.
+func (rr *recordReader) next() (record []byte, line int64, quoted bool, err error) {
+	for len(record) == 0 {
+		rr.start = rr.offset
+		if record, err = rr.readLine(); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	line = rr.line
+
+	if bytes.IndexByte(record, '"') < 0 {
+		return record, line, false, nil
+	}
+	if endsInQuotedField(record) {
+		rr.joined = append(rr.joined[:0], record...)
+		for endsInQuotedField(rr.joined) {
+			more, err := rr.readLine()
+			if err == io.EOF {
+				break // encoding/csv reports the missing quote
+			}
+			if err != nil {
+				return nil, 0, false, err
+			}
+			rr.joined = append(append(rr.joined, '\n'), more...)
+		}
+		record = rr.joined
+	}
+	return record, line, true, nil
+}
+
+// readLine returns the next line without its line break, or io.EOF
+func (rr *recordReader) readLine() ([]byte, error) {
+	data, err := rr.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		rr.long = append(rr.long[:0], data...)
+		for err == bufio.ErrBufferFull {
+			data, err = rr.reader.ReadSlice('\n')
+			rr.long = append(rr.long, data...)
+		}
+		data = rr.long
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	rr.line++
+	rr.offset += int64(len(data))
+
+	data = bytes.TrimSuffix(data, []byte{'\n'})
+	return bytes.TrimSuffix(data, []byte{'\r'}), nil
+}
+
+// skipTo moves on to the record starting at offset, on line, past the
+// header. Sources that can seek, such as uncompressed files, are
+// repositioned; others are read up to offset and discarded.
+func (rr *recordReader) skipTo(offset, line int64) error {
+	if offset < rr.offset {
+		return fmt.Errorf("resume offset %d is inside the header", offset)
+	}
+	if seeker, ok := rr.source.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err == nil {
+			rr.reader.Reset(rr.source)
+			rr.offset, rr.line = offset, line-1
+			return nil
+		}
+	}
+	if _, err := io.CopyN(io.Discard, rr.reader, offset-rr.offset); err != nil {
+		return fmt.Errorf("skipping to offset %d: %v", offset, err)
+	}
+	rr.offset, rr.line = offset, line-1
+	return nil
+}
+
+// endsInQuotedField reports whether record stops inside a quoted field, so
+// the record continues on the next line
+func endsInQuotedField(record []byte) bool {
+	inQuotes, fieldStart := false, true
+	for i := 0; i < len(record); i++ {
+		c := record[i]
+		switch {
+		case inQuotes && c == '"':
+			if i+1 < len(record) && record[i+1] == '"' {
+				i++ // Escaped quote
+			} else {
+				inQuotes = false
+			}
+		case !inQuotes && c == '"' && fieldStart:
+			inQuotes = true
+		}
+		fieldStart = !inQuotes && c == ','
+	}
+	return inQuotes
+}
+
+// isFixed reports whether the columns are exactly id_delivery,lat,lng,timestamp
+func (m *columnMap) isFixed() bool {
+	return m.id == 0 && m.lat == 1 && m.lng == 2 && m.timestamp == 3 && m.width == 4 && m.accuracy < 0 && len(m.extra) == 0
+}
+
+// parseFixedRecord parses an unquoted id_delivery,lat,lng,timestamp record
+// without allocating. ok is false if the record is not valid in that layout;
+// columnMap.parse then explains why.
+func parseFixedRecord(record []byte, format utils.TimestampFormat) (point models.DeliveryPoint, ok bool) {
+	var fields [4][]byte
+	n, start := 0, 0
+	for i, c := range record {
+		if c == ',' {
+			if n == 3 {
+				return point, false
+			}
+			fields[n] = record[start:i]
+			n++
+			start = i + 1
+		}
+	}
+	if n != 3 {
+		return point, false
+	}
+	fields[3] = record[start:]
+
+	var err error
+	if point.ID, err = strconv.ParseInt(string(fields[0]), 10, 64); err != nil {
+		return point, false
+	}
+	if point.Latitude, err = strconv.ParseFloat(string(fields[1]), 64); err != nil {
+		return point, false
+	}
+	if point.Longitude, err = strconv.ParseFloat(string(fields[2]), 64); err != nil {
+		return point, false
+	}
+	if point.Timestamp, err = utils.ParseTimestampBytes(fields[3], format); err != nil {
+		return point, false
+	}
+	return point, true
+}
+
+// parseRecord turns a record from recordReader into a row, or the reject
+// explaining why it could not be parsed. Unquoted rows in the fixed layout
+// keep the raw bytes, which the grouper copies; others keep their fields,
+// and quoted rows the raw bytes as well, since the fields cannot be joined
+// back into them.
+func parseRecord(record []byte, line int64, quoted bool, columns *columnMap) (row, *models.RejectedRow) {
+	if !quoted && columns.isFixed() {
+		if point, ok := parseFixedRecord(record, columns.format); ok {
+			return row{point: point, line: line, raw: record}, nil
+		}
+	}
+
+	var fields []string
+	if quoted {
+		reader := csv.NewReader(bytes.NewReader(record))
+		reader.FieldsPerRecord = -1
+		var err error
+		fields, err = reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return row{}, &models.RejectedRow{Line: line + int64(parseErr.StartLine) - 1, Reason: models.RejectCSVSyntax, Detail: parseErr.Err.Error(), Record: fields, Raw: string(record)}
+		}
+	} else {
+		fields = strings.Split(string(record), ",")
+	}
+
+	point, err := columns.parse(fields)
+	if err != nil {
+		reason := models.RejectParseError
+		if errors.Is(err, errRecordLength) {
+			reason = models.RejectColumnCount
+		}
+		return row{}, &models.RejectedRow{Line: line, Reason: reason, Detail: err.Error(), Record: fields, Raw: string(record)}
+	}
+	if quoted {
+		return row{point: point, line: line, record: fields, raw: record}, nil
+	}
+	return row{point: point, line: line, record: fields}, nil
+}
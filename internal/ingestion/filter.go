@@ -0,0 +1,236 @@
+package ingestion
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// Verdict is a filter's decision about one point; the zero Verdict keeps it
+type Verdict struct {
+	Reason models.RejectReason
+	Detail string
+}
+
+// PointFilter drops points from a single delivery. It sees the delivery's
+// points in timestamp order, minus those dropped by earlier filters in the
+// chain, and records a Verdict for each point it rejects.
+type PointFilter interface {
+	Filter(points []models.DeliveryPoint, verdicts []Verdict)
+}
+
+// FilterChain runs its filters in order
+type FilterChain []PointFilter
+
+// DefaultFilterNames is the chain used when Options.Filters is nil
+var DefaultFilterNames = []string{"duplicate-timestamp", "speed"}
+
+// FilterParams holds the settings that filters are built from
+type FilterParams struct {
+	MaxSpeed    float64         // km/h, for "speed"
+	SpeedMode   SpeedFilterMode // for "speed"
+	MinAccuracy float64         // largest accepted accuracy radius in meters, for "min-accuracy"
+	Geofence    BoundingBox     // for "geofence"
+}
+
+// FilterFactory builds a filter from the command-line settings
+type FilterFactory func(params FilterParams) (PointFilter, error)
+
+var filterFactories = map[string]FilterFactory{
+	"speed": func(params FilterParams) (PointFilter, error) {
+		if params.MaxSpeed < 0 {
+			return nil, fmt.Errorf("max speed must not be negative")
+		}
+		return SpeedFilter{MaxSpeed: params.MaxSpeed, Mode: params.SpeedMode}, nil
+	},
+	"range":       func(FilterParams) (PointFilter, error) { return CoordinateRangeFilter{}, nil },
+	"null-island": func(FilterParams) (PointFilter, error) { return NullIslandFilter{}, nil },
+	"duplicate-timestamp": func(FilterParams) (PointFilter, error) {
+		return DuplicateTimestampFilter{}, nil
+	},
+	"min-accuracy": func(params FilterParams) (PointFilter, error) {
+		if params.MinAccuracy <= 0 {
+			return nil, fmt.Errorf("needs a positive minimum accuracy")
+		}
+		return AccuracyFilter{MaxRadius: params.MinAccuracy}, nil
+	},
+	"geofence": func(params FilterParams) (PointFilter, error) {
+		if params.Geofence == (BoundingBox{}) {
+			return nil, fmt.Errorf("needs a bounding box")
+		}
+		return GeofenceFilter{Box: params.Geofence}, nil
+	},
+}
+
+// RegisterFilter makes a filter available to NewFilterChain under name
+func RegisterFilter(name string, factory FilterFactory) {
+	filterFactories[name] = factory
+}
+
+// FilterNames lists the registered filters
+func FilterNames() []string {
+	names := make([]string, 0, len(filterFactories))
+	for name := range filterFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFilterChain builds the named filters, in order
+func NewFilterChain(names []string, params FilterParams) (FilterChain, error) {
+	chain := make(FilterChain, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		factory, ok := filterFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q (available: %s)", name, strings.Join(FilterNames(), ", "))
+		}
+		filter, err := factory(params)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %v", name, err)
+		}
+		chain = append(chain, filter)
+	}
+	return chain, nil
+}
+
+// apply runs the chain over a delivery's time-ordered rows, compacting rows
+// in place, and returns the points that passed every filter
+func (c FilterChain) apply(rows []row, reject func(r row, reason models.RejectReason, detail string)) []models.DeliveryPoint {
+	points := make([]models.DeliveryPoint, len(rows))
+	for i, r := range rows {
+		points[i] = r.point
+	}
+
+	verdicts := make([]Verdict, len(points)) // Shared by the filters
+	for _, filter := range c {
+		if len(points) == 0 {
+			break
+		}
+		verdicts = verdicts[:len(points)]
+		clear(verdicts)
+		filter.Filter(points, verdicts)
+
+		kept := 0
+		for i := range points {
+			if verdicts[i].Reason != "" {
+				reject(rows[i], verdicts[i].Reason, verdicts[i].Detail)
+				continue
+			}
+			points[kept], rows[kept] = points[i], rows[i]
+			kept++
+		}
+		points, rows = points[:kept], rows[:kept]
+	}
+	return points
+}
+
+// DuplicateTimestampFilter keeps only the first point for each timestamp
+type DuplicateTimestampFilter struct{}
+
+func (DuplicateTimestampFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	last := 0
+	for i := 1; i < len(points); i++ {
+		prev := points[last]
+		if !points[i].Timestamp.Equal(prev.Timestamp) {
+			last = i
+			continue
+		}
+		at := prev.Timestamp.Format(time.RFC3339)
+		if points[i].Latitude == prev.Latitude && points[i].Longitude == prev.Longitude {
+			verdicts[i] = Verdict{models.RejectDuplicate, "duplicate of the point at " + at}
+		} else {
+			verdicts[i] = Verdict{models.RejectTimeConflict, "different coordinates from the point at " + at}
+		}
+	}
+}
+
+// CoordinateRangeFilter drops points outside valid latitudes and longitudes
+type CoordinateRangeFilter struct{}
+
+func (CoordinateRangeFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	for i, p := range points {
+		if !(p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180) { // Also catches NaN
+			verdicts[i] = Verdict{models.RejectOutOfRange, fmt.Sprintf("coordinates %v,%v out of range", p.Latitude, p.Longitude)}
+		}
+	}
+}
+
+// nullIslandTolerance is how close to 0,0 (in degrees) a point must be to
+// count as an unset fix
+const nullIslandTolerance = 1e-6
+
+// NullIslandFilter drops points at 0,0, which GPS modules report when they have no fix
+type NullIslandFilter struct{}
+
+func (NullIslandFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	for i, p := range points {
+		if math.Abs(p.Latitude) < nullIslandTolerance && math.Abs(p.Longitude) < nullIslandTolerance {
+			verdicts[i] = Verdict{models.RejectNullIsland, "point at 0,0"}
+		}
+	}
+}
+
+// AccuracyFilter drops points whose reported accuracy radius is larger than
+// MaxRadius meters. Points without accuracy information are kept.
+type AccuracyFilter struct {
+	MaxRadius float64
+}
+
+func (f AccuracyFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	for i, p := range points {
+		if p.Accuracy > f.MaxRadius {
+			verdicts[i] = Verdict{models.RejectLowAccuracy, fmt.Sprintf("accuracy %.0f m worse than %.0f m", p.Accuracy, f.MaxRadius)}
+		}
+	}
+}
+
+// BoundingBox is a latitude/longitude rectangle, edges included
+type BoundingBox struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// ParseBoundingBox parses "minLat,minLng,maxLat,maxLng"
+func ParseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("bounding box %q: want minLat,minLng,maxLat,maxLng", s)
+	}
+	var values [4]float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("bounding box %q: %v", s, err)
+		}
+		values[i] = value
+	}
+	box := BoundingBox{MinLat: values[0], MinLng: values[1], MaxLat: values[2], MaxLng: values[3]}
+	if box.MinLat > box.MaxLat || box.MinLng > box.MaxLng {
+		return BoundingBox{}, fmt.Errorf("bounding box %q: minimums exceed maximums", s)
+	}
+	return box, nil
+}
+
+// Contains reports whether lat,lng lies within the box
+func (b BoundingBox) Contains(lat, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+// GeofenceFilter drops points outside Box
+type GeofenceFilter struct {
+	Box BoundingBox
+}
+
+func (f GeofenceFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	for i, p := range points {
+		if !f.Box.Contains(p.Latitude, p.Longitude) {
+			verdicts[i] = Verdict{models.RejectOutsideGeofence, fmt.Sprintf("%v,%v outside the geofence", p.Latitude, p.Longitude)}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ingestion
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// rejectedIndexes lists the positions a filter gave a verdict, with their reasons
+func rejectedIndexes(filter PointFilter, points []models.DeliveryPoint) map[int]models.RejectReason {
+	verdicts := make([]Verdict, len(points))
+	filter.Filter(points, verdicts)
+	rejected := map[int]models.RejectReason{}
+	for i, verdict := range verdicts {
+		if verdict.Reason != "" {
+			rejected[i] = verdict.Reason
+		}
+	}
+	return rejected
+}
+
+func TestPointFilters(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2023, 1, 1, 12, minute, 0, 0, time.UTC) }
+	points := []models.DeliveryPoint{
+		{Latitude: 35.70, Longitude: 51.40, Timestamp: at(0), Accuracy: 5},
+		{Latitude: 35.70, Longitude: 51.40, Timestamp: at(0)},
+		{Latitude: 35.71, Longitude: 51.40, Timestamp: at(0), Accuracy: 80},
+		{Latitude: 0, Longitude: 0, Timestamp: at(1)},
+		{Latitude: 95.00, Longitude: 51.40, Timestamp: at(2)},
+		{Latitude: 35.72, Longitude: -181, Timestamp: at(3)},
+		{Latitude: 40.00, Longitude: 51.40, Timestamp: at(4), Accuracy: 20},
+	}
+
+	tests := []struct {
+		name     string
+		filter   PointFilter
+		expected map[int]models.RejectReason
+	}{
+		{"Duplicate timestamp", DuplicateTimestampFilter{}, map[int]models.RejectReason{
+			1: models.RejectDuplicate,
+			2: models.RejectTimeConflict,
+		}},
+		{"Coordinate range", CoordinateRangeFilter{}, map[int]models.RejectReason{
+			4: models.RejectOutOfRange,
+			5: models.RejectOutOfRange,
+		}},
+		{"Null island", NullIslandFilter{}, map[int]models.RejectReason{
+			3: models.RejectNullIsland,
+		}},
+		{"Accuracy", AccuracyFilter{MaxRadius: 50}, map[int]models.RejectReason{
+			2: models.RejectLowAccuracy,
+		}},
+		{"Geofence", GeofenceFilter{Box: BoundingBox{MinLat: 35, MinLng: 51, MaxLat: 36, MaxLng: 52}}, map[int]models.RejectReason{
+			3: models.RejectOutsideGeofence,
+			4: models.RejectOutsideGeofence,
+			5: models.RejectOutsideGeofence,
+			6: models.RejectOutsideGeofence,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := rejectedIndexes(tt.filter, points)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Filter() rejected %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewFilterChain(t *testing.T) {
+	chain, err := NewFilterChain([]string{"range", " null-island", "speed"}, FilterParams{MaxSpeed: 80, SpeedMode: SpeedFilterAnchor})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := FilterChain{CoordinateRangeFilter{}, NullIslandFilter{}, SpeedFilter{MaxSpeed: 80, Mode: SpeedFilterAnchor}}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Errorf("NewFilterChain() = %#v, want %#v", chain, expected)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		names  []string
+		params FilterParams
+	}{
+		{"Unknown filter", []string{"kalman"}, FilterParams{}},
+		{"Accuracy without a limit", []string{"min-accuracy"}, FilterParams{}},
+		{"Geofence without a box", []string{"geofence"}, FilterParams{}},
+	} {
+		if _, err := NewFilterChain(tt.names, tt.params); err == nil {
+			t.Errorf("%s: expected an error, but got none", tt.name)
+		}
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("test-drop-all", func(FilterParams) (PointFilter, error) { return dropAllFilter{}, nil })
+	defer delete(filterFactories, "test-drop-all")
+
+	chain, err := NewFilterChain([]string{"test-drop-all"}, FilterParams{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	input := `id,lat,lng,timestamp
+1,35.7000,51.4000,1609459200
+2,35.7000,51.4000,1609459200`
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		Filters:  chain,
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	if deliveries := collectDeliveries(t, pointsChan, errChan); len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries, got %+v", deliveries)
+	}
+	if len(rejects) != 2 || rejects[0].Line != 2 || rejects[1].Line != 3 || rejects[0].Reason != "test" {
+		t.Errorf("Unexpected rejects: %+v", rejects)
+	}
+}
+
+type dropAllFilter struct{}
+
+func (dropAllFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	for i := range verdicts {
+		verdicts[i] = Verdict{Reason: "test", Detail: "dropped"}
+	}
+}
+
+func TestFilterChainOrder(t *testing.T) {
+	// The anchor speed check keeps the first point, so the null-island fix
+	// must be removed before it runs
+	input := `id,lat,lng,timestamp
+1,0,0,1609459200
+1,35.7000,51.4000,1609459260
+1,35.7054,51.4000,1609459320`
+
+	for _, tt := range []struct {
+		filters FilterChain
+		points  int
+	}{
+		{FilterChain{NullIslandFilter{}, SpeedFilter{Mode: SpeedFilterAnchor}}, 2},
+		{FilterChain{SpeedFilter{Mode: SpeedFilterAnchor}, NullIslandFilter{}}, 0},
+		{FilterChain{}, 3},
+	} {
+		pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{Filters: tt.filters})
+		deliveries := collectDeliveries(t, pointsChan, errChan)
+		if tt.points == 0 {
+			if len(deliveries) != 0 {
+				t.Errorf("Filters %v: expected no deliveries, got %+v", tt.filters, deliveries)
+			}
+			continue
+		}
+		if len(deliveries) != 1 || len(deliveries[0].Points) != tt.points {
+			t.Errorf("Filters %v: expected 1 delivery with %d points, got %+v", tt.filters, tt.points, deliveries)
+		}
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      BoundingBox
+		expectedError bool
+	}{
+		{"35.5,51.1,35.9,51.7", BoundingBox{35.5, 51.1, 35.9, 51.7}, false},
+		{" -1, -2, 1, 2", BoundingBox{-1, -2, 1, 2}, false},
+		{"35.5,51.1,35.9", BoundingBox{}, true},
+		{"35.9,51.1,35.5,51.7", BoundingBox{}, true},
+		{"a,b,c,d", BoundingBox{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseBoundingBox(tt.input)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("ParseBoundingBox(%q) = %v, %v; want %v", tt.input, result, err, tt.expected)
+			}
+		})
+	}
+}
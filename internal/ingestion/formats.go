@@ -0,0 +1,102 @@
+package ingestion
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"SBCFAA/internal/models"
+)
+
+// InputFormat is the encoding of an input's rows
+type InputFormat int
+
+const (
+	// FormatAuto picks the format from each file's extension, see
+	// DetectInputFormat. Unnamed inputs are read as CSV.
+	FormatAuto InputFormat = iota
+	FormatCSV
+	FormatNDJSON // one JSON object per line
+	FormatGPX    // GPS Exchange Format tracks
+)
+
+var inputFormatNames = map[string]InputFormat{
+	"auto":   FormatAuto,
+	"csv":    FormatCSV,
+	"ndjson": FormatNDJSON,
+	"gpx":    FormatGPX,
+}
+
+// formatExtensions maps file extensions, after any compression extension,
+// to the format they imply
+var formatExtensions = map[string]InputFormat{
+	".csv":    FormatCSV,
+	".ndjson": FormatNDJSON,
+	".jsonl":  FormatNDJSON,
+	".json":   FormatNDJSON,
+	".gpx":    FormatGPX,
+}
+
+// compressionExtensions are stripped before looking at the format extension
+var compressionExtensions = []string{".gz", ".gzip", ".zst", ".zstd", ".bz2"}
+
+// ParseInputFormat converts a command-line value (auto, csv, ndjson or gpx) to an InputFormat
+func ParseInputFormat(s string) (InputFormat, error) {
+	if format, ok := inputFormatNames[s]; ok {
+		return format, nil
+	}
+	return 0, fmt.Errorf("unknown input format %q (want auto, csv, ndjson or gpx)", s)
+}
+
+// DetectInputFormat infers the format from name's extension, looking past a
+// compression extension, so trips.jsonl.gz is NDJSON. It returns FormatCSV
+// when the extension says nothing.
+func DetectInputFormat(name string) InputFormat {
+	name = strings.ToLower(name)
+	for _, ext := range compressionExtensions {
+		if strings.HasSuffix(name, ext) {
+			name = strings.TrimSuffix(name, ext)
+			break
+		}
+	}
+	if format, ok := formatExtensions[filepath.Ext(name)]; ok {
+		return format
+	}
+	return FormatCSV
+}
+
+// resolve returns the format to read the input called name with
+func (f InputFormat) resolve(name string) InputFormat {
+	if f == FormatAuto {
+		return DetectInputFormat(name)
+	}
+	return f
+}
+
+// rowReader decodes the rows of one input, whatever its format
+type rowReader interface {
+	// next returns the next row, or the reject explaining why a row could
+	// not be parsed, and io.EOF after the last one. The row is only valid
+	// until the next call.
+	next() (row, *models.RejectedRow, error)
+}
+
+// resumableReader is a rowReader that can start part way through its input
+type resumableReader interface {
+	rowReader
+	// skipTo moves on to the row at offset, which is on line
+	skipTo(offset, line int64) error
+}
+
+// newRowReader reads whatever header format has and returns a reader for
+// the rows after it. Errors wrap ErrUnreadableInput.
+func newRowReader(format InputFormat, r io.Reader, opts Options) (rowReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return newNDJSONReader(r, opts), nil
+	case FormatGPX:
+		return newGPXReader(r), nil
+	}
+	return newCSVRowReader(r, opts)
+}
@@ -0,0 +1,131 @@
+package ingestion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"SBCFAA/internal/models"
+)
+
+// writeTempInput writes content to a file called name in a fresh temporary directory
+func writeTempInput(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+// deliveryPoints drops everything but the points, which do not depend on
+// where in its input a delivery was found
+func deliveryPoints(deliveries []models.Delivery) [][]models.DeliveryPoint {
+	points := make([][]models.DeliveryPoint, len(deliveries))
+	for i, delivery := range deliveries {
+		points[i] = delivery.Points
+	}
+	return points
+}
+
+func TestParseInputFormat(t *testing.T) {
+	for name, expected := range map[string]InputFormat{"auto": FormatAuto, "csv": FormatCSV, "ndjson": FormatNDJSON, "gpx": FormatGPX} {
+		if result, err := ParseInputFormat(name); err != nil || result != expected {
+			t.Errorf("ParseInputFormat(%q) = %v, %v; want %v", name, result, err, expected)
+		}
+	}
+	if _, err := ParseInputFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestDetectInputFormat(t *testing.T) {
+	tests := map[string]InputFormat{
+		"trips.csv":        FormatCSV,
+		"trips.csv.gz":     FormatCSV,
+		"trips.ndjson":     FormatNDJSON,
+		"trips.JSONL.zst":  FormatNDJSON,
+		"trips.json.bz2":   FormatNDJSON,
+		"morning.gpx":      FormatGPX,
+		"morning.gpx.gzip": FormatGPX,
+		"trips.txt":        FormatCSV,
+		"trips":            FormatCSV,
+		"":                 FormatCSV,
+	}
+	for name, expected := range tests {
+		if result := DetectInputFormat(name); result != expected {
+			t.Errorf("DetectInputFormat(%q) = %v, want %v", name, result, expected)
+		}
+	}
+}
+
+// TestReadInputFormats reads the same two deliveries in every format
+func TestReadInputFormats(t *testing.T) {
+	csvPath := writeTempInput(t, "trips.csv", `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+1,40.7129,-74.0061,1609459260
+2,40.7130,-74.0062,1609459320`)
+	ndjsonPath := writeTempInput(t, "trips.jsonl", `{"id_delivery": 1, "lat": 40.7128, "lng": -74.0060, "timestamp": 1609459200}
+{"id_delivery": 1, "lat": 40.7129, "lng": -74.0061, "timestamp": 1609459260}
+{"id_delivery": 2, "lat": 40.7130, "lng": -74.0062, "timestamp": 1609459320}`)
+	gpxPath := writeTempInput(t, "trips.gpx", `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><number>1</number><trkseg>
+    <trkpt lat="40.7128" lon="-74.0060"><time>2021-01-01T00:00:00Z</time></trkpt>
+    <trkpt lat="40.7129" lon="-74.0061"><time>2021-01-01T00:01:00Z</time></trkpt>
+  </trkseg></trk>
+  <trk><name>2</name><trkseg>
+    <trkpt lat="40.7130" lon="-74.0062"><time>2021-01-01T00:02:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`)
+	unnamedPath := writeTempInput(t, "trips.txt", `{"id": 1, "lat": 40.7128, "lng": -74.0060, "ts": 1609459200}
+{"id": 1, "lat": 40.7129, "lng": -74.0061, "ts": 1609459260}
+{"id": 2, "lat": 40.7130, "lng": -74.0062, "ts": 1609459320}`)
+
+	pointsChan, errChan := ReadAndFilterCSV(csvPath)
+	want := deliveryPoints(collectDeliveries(t, pointsChan, errChan))
+	if len(want) != 2 {
+		t.Fatalf("Expected 2 deliveries from CSV, got %d", len(want))
+	}
+	tests := []struct {
+		name   string
+		path   string
+		format InputFormat
+	}{
+		{"NDJSON by extension", ndjsonPath, FormatAuto},
+		{"GPX by extension", gpxPath, FormatAuto},
+		{"NDJSON by option", unnamedPath, FormatNDJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, workers := range []int{1, 4} { // Chunked parsing is CSV only
+				pointsChan, errChan := ReadAndFilterCSVWithOptions(tt.path, Options{Format: tt.format, ParseWorkers: workers})
+				result := deliveryPoints(collectDeliveries(t, pointsChan, errChan))
+				if !reflect.DeepEqual(result, want) {
+					t.Errorf("workers %d: got %+v, want %+v", workers, result, want)
+				}
+			}
+		})
+	}
+
+	// One run may mix formats; the GPX points repeat the CSV ones
+	var rejects []models.RejectedRow
+	pointsChan, errChan = ReadAndFilterCSVFiles(context.Background(), []string{csvPath, gpxPath}, Options{
+		Grouping: GroupExternal,
+		TempDir:  t.TempDir(),
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	if deliveries := deliveryPoints(collectDeliveries(t, pointsChan, errChan)); !reflect.DeepEqual(deliveries, want) {
+		t.Errorf("Mixed inputs: got %+v, want %+v", deliveries, want)
+	}
+	if len(rejects) != 3 {
+		t.Fatalf("Mixed inputs: expected 3 duplicates, got %+v", rejects)
+	}
+	for _, row := range rejects {
+		if row.File != gpxPath || row.Reason != models.RejectDuplicate {
+			t.Errorf("Mixed inputs: expected a duplicate from the GPX file, got %+v", row)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ingestion
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+// gpxReader is the rowReader for GPX files. Each track (<trk>) is one
+// delivery, identified by its <number>, or by its <name> when that is an
+// integer; points of tracks with neither are rejected. Waypoints and routes
+// are ignored.
+type gpxReader struct {
+	decoder *xml.Decoder
+	columns *columnMap
+	path    []string // names of the open elements
+	track   int      // tracks seen so far
+	number  string   // the current track's <number>
+	name    string   // the current track's <name>
+}
+
+// gpxPoint is a <trkpt> element
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Time string `xml:"time"`
+}
+
+func newGPXReader(r io.Reader) *gpxReader {
+	return &gpxReader{
+		decoder: xml.NewDecoder(bufio.NewReader(r)),
+		// Points are parsed as id, lat, lon, time records; GPX times are RFC 3339
+		columns: &columnMap{id: 0, lat: 1, lng: 2, timestamp: 3, accuracy: -1, width: 4, format: utils.TimestampRFC3339},
+	}
+}
+
+func (g *gpxReader) next() (row, *models.RejectedRow, error) {
+	for {
+		token, err := g.decoder.Token()
+		if err == io.EOF {
+			return row{}, nil, io.EOF
+		}
+		if err != nil {
+			return row{}, nil, fmt.Errorf("reading GPX: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.EndElement:
+			g.path = g.path[:len(g.path)-1]
+		case xml.StartElement:
+			parent := ""
+			if len(g.path) > 0 {
+				parent = g.path[len(g.path)-1]
+			}
+
+			switch {
+			case t.Name.Local == "trk":
+				g.track++
+				g.number, g.name = "", ""
+			case parent == "trk" && (t.Name.Local == "number" || t.Name.Local == "name"):
+				var text string
+				if err := g.decoder.DecodeElement(&text, &t); err != nil {
+					return row{}, nil, fmt.Errorf("reading GPX: %v", err)
+				}
+				if t.Name.Local == "number" {
+					g.number = strings.TrimSpace(text)
+				} else {
+					g.name = strings.TrimSpace(text)
+				}
+				continue // DecodeElement consumed the end element
+			case parent == "trkseg" && t.Name.Local == "trkpt":
+				line, _ := g.decoder.InputPos()
+				var point gpxPoint
+				if err := g.decoder.DecodeElement(&point, &t); err != nil {
+					return row{}, nil, fmt.Errorf("reading GPX: %v", err)
+				}
+				parsed, rejected := g.parse(point, int64(line))
+				return parsed, rejected, nil
+			}
+			g.path = append(g.path, t.Name.Local)
+		}
+	}
+}
+
+// parse builds a row from a track point that starts on line
+func (g *gpxReader) parse(point gpxPoint, line int64) (row, *models.RejectedRow) {
+	record := []string{g.deliveryID(), strings.TrimSpace(point.Lat), strings.TrimSpace(point.Lon), strings.TrimSpace(point.Time)}
+	if record[0] == "" {
+		detail := fmt.Sprintf("track %d has no numeric <number> or <name>", g.track)
+		return row{}, &models.RejectedRow{Line: line, Reason: models.RejectParseError, Detail: detail, Record: record}
+	}
+	parsed, err := g.columns.parse(record)
+	if err != nil {
+		return row{}, &models.RejectedRow{Line: line, Reason: models.RejectParseError, Detail: err.Error(), Record: record}
+	}
+	// Resuming would need the enclosing track, so points have no offset
+	return row{point: parsed, line: line, offset: -1, record: record}, nil
+}
+
+// deliveryID returns the current track's id, or "" if it has none
+func (g *gpxReader) deliveryID() string {
+	for _, id := range []string{g.number, g.name} {
+		if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package ingestion
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+func TestGPXReader(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <metadata><name>Morning shift</name></metadata>
+  <wpt lat="1" lon="1"><name>Depot</name></wpt>
+  <trk>
+    <name>Courier 17</name>
+    <number>42</number>
+    <trkseg>
+      <trkpt lat="35.7000" lon="51.4000"><ele>1200</ele><time>2021-01-01T00:00:00Z</time><name>ignored</name></trkpt>
+      <trkpt lat="35.7010" lon="51.4010"><time>2021-01-01T03:31:00+03:30</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="north" lon="51.4020"><time>2021-01-01T00:02:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+  <trk>
+    <name> 43 </name>
+    <trkseg><trkpt lat="35.8000" lon="51.5000"><time>2021-01-01T00:00:00Z</time></trkpt></trkseg>
+  </trk>
+  <trk>
+    <name>Unnamed</name>
+    <trkseg><trkpt lat="35.9000" lon="51.6000"><time>2021-01-01T00:00:00Z</time></trkpt></trkseg>
+  </trk>
+</gpx>`
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	type result struct {
+		line   int64
+		point  models.DeliveryPoint
+		record []string
+		detail string
+	}
+	expected := []result{
+		{line: 9, point: models.DeliveryPoint{ID: 42, Latitude: 35.7, Longitude: 51.4, Timestamp: base}, record: []string{"42", "35.7000", "51.4000", "2021-01-01T00:00:00Z"}},
+		{line: 10, point: models.DeliveryPoint{ID: 42, Latitude: 35.701, Longitude: 51.401, Timestamp: base.Add(time.Minute)}, record: []string{"42", "35.7010", "51.4010", "2021-01-01T03:31:00+03:30"}},
+		{line: 13, record: []string{"42", "north", "51.4020", "2021-01-01T00:02:00Z"}, detail: `strconv.ParseFloat: parsing "north": invalid syntax`},
+		{line: 18, point: models.DeliveryPoint{ID: 43, Latitude: 35.8, Longitude: 51.5, Timestamp: base}, record: []string{"43", "35.8000", "51.5000", "2021-01-01T00:00:00Z"}},
+		{line: 22, record: []string{"", "35.9000", "51.6000", "2021-01-01T00:00:00Z"}, detail: "track 3 has no numeric <number> or <name>"},
+	}
+
+	reader := newGPXReader(strings.NewReader(input))
+	var results []result
+	for {
+		parsed, rejected, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rejected != nil {
+			results = append(results, result{line: rejected.Line, record: rejected.Record, detail: rejected.Detail})
+			continue
+		}
+		results = append(results, result{line: parsed.line, point: parsed.point, record: parsed.record})
+	}
+
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Got %+v\nwant %+v", results, expected)
+	}
+}
+
+func TestGPXReaderMalformed(t *testing.T) {
+	reader := newGPXReader(strings.NewReader(`<gpx><trk><number>1</number><trkseg><trkpt lat="1" lon="1">`))
+	for {
+		_, _, err := reader.next()
+		if err == io.EOF {
+			t.Fatal("Expected an error for truncated XML, got EOF")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,602 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// GroupingMode selects how rows are assembled into deliveries
+type GroupingMode int
+
+const (
+	// GroupContiguous starts a new delivery whenever id_delivery changes. It
+	// is the fast path for input that is already grouped by delivery.
+	GroupContiguous GroupingMode = iota
+
+	// GroupExternal sorts rows by id_delivery before grouping, so input in
+	// any order works. Sorted runs of at most Options.SortBufferRows rows are
+	// spilled to disk and merged, keeping memory bounded. Deliveries come out
+	// in ascending id order; rows of one delivery keep their input order.
+	GroupExternal
+)
+
+const defaultSortBufferRows = 1 << 20
+
+// ParseGroupingMode converts a command-line value ("contiguous" or "external") to a GroupingMode
+func ParseGroupingMode(s string) (GroupingMode, error) {
+	switch s {
+	case "contiguous":
+		return GroupContiguous, nil
+	case "external":
+		return GroupExternal, nil
+	}
+	return 0, fmt.Errorf("unknown grouping %q (want contiguous or external)", s)
+}
+
+// OverflowPolicy selects what happens to a delivery that outgrows its DeliveryLimits
+type OverflowPolicy int
+
+const (
+	// OverflowSplit emits the delivery in parts that each stay within the
+	// limits; the fare calculator prices the parts as one delivery
+	OverflowSplit OverflowPolicy = iota
+
+	// OverflowQuarantine drops the whole delivery and reports it through
+	// Options.OnQuarantine
+	OverflowQuarantine
+)
+
+// ParseOverflowPolicy converts a command-line value ("split" or "quarantine") to an OverflowPolicy
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "split":
+		return OverflowSplit, nil
+	case "quarantine":
+		return OverflowQuarantine, nil
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q (want split or quarantine)", s)
+}
+
+// DeliveryLimits bounds the rows held for one delivery, so that a device
+// stuck pinging for days cannot exhaust memory. Zero means no limit.
+type DeliveryLimits struct {
+	MaxPoints   int           // rows in one delivery
+	MaxDuration time.Duration // time between its earliest and latest rows
+	Overflow    OverflowPolicy
+}
+
+// grouper assembles rows into per-delivery groups and hands each group to
+// its emit function. The slice passed to emit is only valid during the call;
+// more is set when further rows of the same delivery will follow, because
+// the group was cut at opts.Limits.
+type grouper interface {
+	add(r row) error
+	flush() error // end of input, emit whatever is left
+	close()       // release resources, safe to call after flush
+}
+
+// emitFunc receives groups from a grouper
+type emitFunc func(rows []row, more bool) error
+
+// quarantineFunc receives the deliveries a grouper dropped for exceeding
+// its limits, with the source of their first row
+type quarantineFunc func(delivery models.QuarantinedDelivery, source int)
+
+func newGrouper(opts Options, emit emitFunc, quarantine quarantineFunc) grouper {
+	if opts.Grouping == GroupExternal {
+		limit := opts.SortBufferRows
+		if limit <= 0 {
+			limit = defaultSortBufferRows
+		}
+		return &externalGrouper{out: newAssembler(opts.Limits, emit, quarantine), tempDir: opts.TempDir, limit: limit, fanIn: mergeFanIn}
+	}
+	return newAssembler(opts.Limits, emit, quarantine)
+}
+
+// keepRaw copies a fast-path row's raw record, which points into the
+// reader's buffer, to the end of arena
+func keepRaw(arena []byte, r row) ([]byte, row) {
+	if r.raw == nil {
+		return arena, r
+	}
+	start := len(arena)
+	arena = append(arena, r.raw...)
+	r.raw = arena[start:len(arena):len(arena)]
+	return arena, r
+}
+
+// assembler emits a group every time the delivery id changes, enforcing
+// limits along the way. It is the grouper for GroupContiguous and the last
+// stage of GroupExternal.
+type assembler struct {
+	limits     DeliveryLimits
+	emit       emitFunc
+	quarantine quarantineFunc
+
+	current     []row
+	arena       []byte    // raw records of current
+	first, last time.Time // earliest and latest timestamps in current
+
+	dropped *models.QuarantinedDelivery // the delivery being quarantined, if any
+	source  int                         // where dropped started
+}
+
+func newAssembler(limits DeliveryLimits, emit emitFunc, quarantine quarantineFunc) *assembler {
+	return &assembler{limits: limits, emit: emit, quarantine: quarantine}
+}
+
+func (a *assembler) add(r row) error {
+	if a.dropped != nil {
+		if a.dropped.ID == r.point.ID {
+			a.dropped.Rows++
+			a.dropped.Start, a.dropped.End = widen(a.dropped.Start, a.dropped.End, r.point.Timestamp)
+			return nil
+		}
+		a.flushDropped()
+	}
+
+	if len(a.current) > 0 {
+		if a.current[0].point.ID != r.point.ID {
+			if err := a.flush(); err != nil {
+				return err
+			}
+		} else if reason := a.exceeds(r); reason != "" {
+			if a.limits.Overflow == OverflowQuarantine {
+				a.drop(r, reason)
+				return nil
+			}
+			if err := a.emit(a.current, true); err != nil {
+				return err
+			}
+			a.reset()
+		}
+	}
+
+	if len(a.current) == 0 {
+		a.first, a.last = r.point.Timestamp, r.point.Timestamp
+	} else {
+		a.first, a.last = widen(a.first, a.last, r.point.Timestamp)
+	}
+	a.arena, r = keepRaw(a.arena, r)
+	a.current = append(a.current, r)
+	return nil
+}
+
+// exceeds returns which limit adding r to current would break, or ""
+func (a *assembler) exceeds(r row) string {
+	if a.limits.MaxPoints > 0 && len(a.current) >= a.limits.MaxPoints {
+		return fmt.Sprintf("more than %d points", a.limits.MaxPoints)
+	}
+	if a.limits.MaxDuration > 0 {
+		if first, last := widen(a.first, a.last, r.point.Timestamp); last.Sub(first) > a.limits.MaxDuration {
+			return fmt.Sprintf("spans more than %v", a.limits.MaxDuration)
+		}
+	}
+	return ""
+}
+
+// drop quarantines the current delivery, which r was about to overflow
+func (a *assembler) drop(r row, reason string) {
+	start, end := widen(a.first, a.last, r.point.Timestamp)
+	a.dropped = &models.QuarantinedDelivery{
+		ID:     r.point.ID,
+		Line:   a.current[0].line,
+		Rows:   int64(len(a.current)) + 1,
+		Start:  start,
+		End:    end,
+		Reason: reason,
+	}
+	a.source = a.current[0].source
+	a.reset()
+}
+
+func (a *assembler) flushDropped() {
+	if a.quarantine != nil {
+		a.quarantine(*a.dropped, a.source)
+	}
+	a.dropped = nil
+}
+
+// reset empties current, reusing its buffers for the next group
+func (a *assembler) reset() {
+	a.current, a.arena = a.current[:0], a.arena[:0]
+}
+
+func (a *assembler) flush() error {
+	if a.dropped != nil {
+		a.flushDropped()
+	}
+	if len(a.current) == 0 {
+		return nil
+	}
+	err := a.emit(a.current, false)
+	a.reset()
+	return err
+}
+
+func (a *assembler) close() {}
+
+// widen extends the range [first, last] to cover ts
+func widen(first, last, ts time.Time) (time.Time, time.Time) {
+	if ts.Before(first) {
+		first = ts
+	}
+	if ts.After(last) {
+		last = ts
+	}
+	return first, last
+}
+
+// mergeFanIn caps the runs merged at once, and so the files open at a time.
+// More runs than that are merged in passes, each writing a new run.
+const mergeFanIn = 128
+
+// externalGrouper is an external merge sort on (id, ordinal)
+type externalGrouper struct {
+	out     *assembler // groups the sorted rows
+	tempDir string
+	limit   int
+	fanIn   int
+	buffer  []row
+	arena   []byte   // raw records of buffer
+	runs    []string // run files, closed until merged
+
+	open, maxOpen int // run files open now and at most, for tests
+}
+
+func (g *externalGrouper) add(r row) error {
+	g.arena, r = keepRaw(g.arena, r)
+	g.buffer = append(g.buffer, r)
+	if len(g.buffer) >= g.limit {
+		return g.spill()
+	}
+	return nil
+}
+
+// spill writes the sorted buffer to a new run file
+func (g *externalGrouper) spill() error {
+	sortRows(g.buffer)
+	path, err := g.writeRun(func(write func(row) error) error {
+		for _, r := range g.buffer {
+			if err := write(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.runs = append(g.runs, path)
+
+	clear(g.buffer) // Drop references to the records
+	g.buffer, g.arena = g.buffer[:0], g.arena[:0]
+	return nil
+}
+
+// writeRun creates a run file, fills it through write and closes it
+func (g *externalGrouper) writeRun(fill func(write func(row) error) error) (string, error) {
+	file, err := os.CreateTemp(g.tempDir, "sbcfaa-group-*.run")
+	if err != nil {
+		return "", fmt.Errorf("error creating sort run: %v", err)
+	}
+	g.opened(1)
+	defer g.opened(-1)
+
+	w := bufio.NewWriter(file)
+	var scratch []byte
+	err = fill(func(r row) error {
+		scratch = encodeRow(scratch[:0], r)
+		_, err := w.Write(scratch)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error writing sort run: %v", err)
+	}
+	return file.Name(), nil
+}
+
+func (g *externalGrouper) opened(n int) {
+	g.open += n
+	g.maxOpen = max(g.maxOpen, g.open)
+}
+
+func (g *externalGrouper) flush() error {
+	if len(g.runs) == 0 { // Everything fit in memory
+		sortRows(g.buffer)
+		return g.assemble(g.buffer)
+	}
+	if len(g.buffer) > 0 {
+		if err := g.spill(); err != nil {
+			return err
+		}
+	}
+
+	for len(g.runs) > g.fanIn {
+		if err := g.mergePass(); err != nil {
+			return err
+		}
+	}
+	if err := g.merge(g.runs, g.out.add); err != nil {
+		return err
+	}
+	return g.out.flush()
+}
+
+// mergePass merges the runs fanIn at a time into fewer, longer runs
+func (g *externalGrouper) mergePass() error {
+	var merged []string
+	for len(g.runs) > 0 {
+		group := g.runs[:min(g.fanIn, len(g.runs))]
+		path, err := g.writeRun(func(write func(row) error) error {
+			return g.merge(group, write)
+		})
+		if err != nil {
+			for _, run := range merged {
+				os.Remove(run)
+			}
+			return err
+		}
+		merged = append(merged, path)
+		for _, run := range group {
+			os.Remove(run)
+		}
+		g.runs = g.runs[len(group):]
+	}
+	g.runs = merged
+	return nil
+}
+
+// merge k-way merges the run files, passing their rows to emit in order
+func (g *externalGrouper) merge(runs []string, emit func(row) error) error {
+	h := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, cursor := range h {
+			cursor.file.Close()
+			g.opened(-1)
+		}
+	}()
+	for _, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return fmt.Errorf("error reading sort run: %v", err)
+		}
+		g.opened(1)
+		cursor := &runCursor{file: file, reader: bufio.NewReader(file)}
+		h = append(h, cursor) // Closed by the deferred loop if next fails
+		if ok, err := cursor.next(); err != nil {
+			return err
+		} else if !ok {
+			h = h[:len(h)-1]
+			file.Close()
+			g.opened(-1)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		cursor := h[0]
+		if err := emit(cursor.current); err != nil {
+			return err
+		}
+
+		ok, err := cursor.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+			cursor.file.Close()
+			g.opened(-1)
+		}
+	}
+	return nil
+}
+
+func (g *externalGrouper) close() {
+	for _, run := range g.runs {
+		os.Remove(run)
+	}
+	g.runs = nil
+}
+
+// sortRows orders rows by delivery id, then input position
+func sortRows(rows []row) {
+	sort.Slice(rows, func(i, j int) bool { return rowLess(rows[i], rows[j]) })
+}
+
+func rowLess(a, b row) bool {
+	if a.point.ID != b.point.ID {
+		return a.point.ID < b.point.ID
+	}
+	return a.ordinal < b.ordinal
+}
+
+// assemble feeds sorted rows to out, which groups runs of equal ids
+func (g *externalGrouper) assemble(rows []row) error {
+	for _, r := range rows {
+		if err := g.out.add(r); err != nil {
+			return err
+		}
+	}
+	return g.out.flush()
+}
+
+// Run files hold rows back to back: id, lat, lng, unix seconds, nanoseconds,
+// accuracy, line, ordinal and source as 8-byte little-endian values, then the raw record as a
+// uvarint field count followed by uvarint-length-prefixed fields, the
+// length-prefixed raw text of a quoted row (empty for others, whose fields
+// join back into it), and the point's extra columns as a uvarint count of
+// length-prefixed name/value pairs.
+const rowHeaderSize = 9 * 8
+
+func encodeRow(buf []byte, r row) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.point.ID))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(r.point.Latitude))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(r.point.Longitude))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.point.Timestamp.Unix()))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.point.Timestamp.Nanosecond()))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(r.point.Accuracy))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.line))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.ordinal))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.source))
+	if r.record == nil {
+		buf = binary.AppendUvarint(buf, uint64(bytes.Count(r.raw, []byte{','})+1))
+		for rest, more := r.raw, true; more; {
+			var field []byte
+			field, rest, more = bytes.Cut(rest, []byte{','})
+			buf = binary.AppendUvarint(buf, uint64(len(field)))
+			buf = append(buf, field...)
+		}
+	} else {
+		buf = binary.AppendUvarint(buf, uint64(len(r.record)))
+		for _, field := range r.record {
+			buf = appendString(buf, field)
+		}
+	}
+	var quoted []byte
+	if r.record != nil {
+		quoted = r.raw
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(quoted)))
+	buf = append(buf, quoted...)
+	buf = binary.AppendUvarint(buf, uint64(len(r.point.Extra)))
+	for name, value := range r.point.Extra {
+		buf = appendString(appendString(buf, name), value)
+	}
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(reader *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", fmt.Errorf("truncated sort run: %v", err)
+	}
+	field := make([]byte, length)
+	if _, err := io.ReadFull(reader, field); err != nil {
+		return "", fmt.Errorf("truncated sort run: %v", err)
+	}
+	return string(field), nil
+}
+
+// decodeRow reads one row; it returns io.EOF only at a clean row boundary
+func decodeRow(reader *bufio.Reader, header []byte) (row, error) {
+	if _, err := io.ReadFull(reader, header[:rowHeaderSize]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return row{}, fmt.Errorf("truncated sort run")
+		}
+		return row{}, err
+	}
+	value := func(i int) uint64 { return binary.LittleEndian.Uint64(header[i*8:]) }
+
+	r := row{
+		point: models.DeliveryPoint{
+			ID:        int64(value(0)),
+			Latitude:  math.Float64frombits(value(1)),
+			Longitude: math.Float64frombits(value(2)),
+			Timestamp: time.Unix(int64(value(3)), int64(value(4))).UTC(),
+			Accuracy:  math.Float64frombits(value(5)),
+		},
+		line:    int64(value(6)),
+		ordinal: int64(value(7)),
+		source:  int(value(8)),
+	}
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return row{}, fmt.Errorf("truncated sort run: %v", err)
+	}
+	r.record = make([]string, count)
+	for i := range r.record {
+		if r.record[i], err = readString(reader); err != nil {
+			return row{}, err
+		}
+	}
+	raw, err := readString(reader)
+	if err != nil {
+		return row{}, err
+	}
+	if raw != "" {
+		r.raw = []byte(raw)
+	}
+
+	count, err = binary.ReadUvarint(reader)
+	if err != nil {
+		return row{}, fmt.Errorf("truncated sort run: %v", err)
+	}
+	if count > 0 {
+		r.point.Extra = make(map[string]string, count)
+	}
+	for ; count > 0; count-- {
+		name, err := readString(reader)
+		if err != nil {
+			return row{}, err
+		}
+		if r.point.Extra[name], err = readString(reader); err != nil {
+			return row{}, err
+		}
+	}
+	return r, nil
+}
+
+// runCursor walks one run file
+type runCursor struct {
+	file    *os.File
+	reader  *bufio.Reader
+	header  [rowHeaderSize]byte
+	current row
+}
+
+// next advances to the following row, reporting false at the end of the run
+func (c *runCursor) next() (bool, error) {
+	r, err := decodeRow(c.reader, c.header[:])
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c.current = r
+	return true, nil
+}
+
+// runHeap orders run cursors by their current row
+type runHeap []*runCursor
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return rowLess(h[i].current, h[j].current) }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(*runCursor)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
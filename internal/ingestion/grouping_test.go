@@ -0,0 +1,375 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// writeTempCSV writes content to a CSV file in a fresh temporary directory
+func writeTempCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+// collectDeliveries drains both channels, failing the test on any error
+func collectDeliveries(t *testing.T, pointsChan <-chan models.Delivery, errChan <-chan error) []models.Delivery {
+	t.Helper()
+	var deliveries []models.Delivery
+	for delivery := range pointsChan {
+		deliveries = append(deliveries, delivery)
+	}
+	for err := range errChan {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	return deliveries
+}
+
+const interleavedInput = `id,lat,lng,timestamp
+3,40.7100,-74.0000,1609459200
+1,40.7200,-74.0000,1609459200
+3,40.7101,-74.0000,1609459260
+2,40.7300,-74.0000,1609459200
+1,40.7201,-74.0000,1609459260
+3,40.7102,-74.0000,1609459320
+2,40.7301,-74.0000,1609459260
+1,40.7202,-74.0000,1609459320`
+
+func TestExternalGrouping(t *testing.T) {
+	path := writeTempCSV(t, interleavedInput)
+
+	for _, bufferRows := range []int{0, 1, 2, 3, 100} { // 0 = default, fits in memory
+		spillDir := t.TempDir()
+		pointsChan, errChan := ReadAndFilterCSVWithOptions(path, Options{
+			Grouping:       GroupExternal,
+			SortBufferRows: bufferRows,
+			TempDir:        spillDir,
+		})
+		deliveries := collectDeliveries(t, pointsChan, errChan)
+
+		if len(deliveries) != 3 {
+			t.Fatalf("buffer %d: expected 3 deliveries, got %d", bufferRows, len(deliveries))
+		}
+		for i, delivery := range deliveries {
+			if delivery.Seq != int64(i) {
+				t.Errorf("buffer %d: delivery %d has Seq %d", bufferRows, i, delivery.Seq)
+			}
+			if delivery.Points[0].ID != int64(i+1) {
+				t.Errorf("buffer %d: expected ascending ids, delivery %d has id %d", bufferRows, i, delivery.Points[0].ID)
+			}
+			for j := 1; j < len(delivery.Points); j++ {
+				if !delivery.Points[j].Timestamp.After(delivery.Points[j-1].Timestamp) {
+					t.Errorf("buffer %d: delivery %d points out of input order", bufferRows, delivery.Points[0].ID)
+				}
+			}
+		}
+		if len(deliveries[0].Points) != 3 || len(deliveries[1].Points) != 2 || len(deliveries[2].Points) != 3 {
+			t.Errorf("buffer %d: unexpected point counts", bufferRows)
+		}
+
+		entries, err := os.ReadDir(spillDir)
+		if err != nil {
+			t.Fatalf("Failed to read spill dir: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("buffer %d: %d sort runs left behind", bufferRows, len(entries))
+		}
+	}
+}
+
+func TestContiguousGroupingSplitsInterleavedInput(t *testing.T) {
+	pointsChan, errChan := ReadAndFilterCSV(writeTempCSV(t, interleavedInput))
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 8 {
+		t.Errorf("Expected one delivery per row on the fast path, got %d", len(deliveries))
+	}
+}
+
+func TestExternalGroupingFiltersAfterGrouping(t *testing.T) {
+	// The jump to 50.71 is only visible once the delivery's rows are together
+	input := `id,lat,lng,timestamp
+1,40.7128,-74.0060,1609459200
+2,40.7128,-74.0060,1609459200
+1,50.7128,-84.0060,1609459260
+2,40.7129,-74.0061,1609459260`
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		Grouping:       GroupExternal,
+		SortBufferRows: 1,
+		TempDir:        t.TempDir(),
+		OnReject:       func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 2 || len(deliveries[0].Points) != 1 || len(deliveries[1].Points) != 2 {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+	if len(rejects) != 1 || rejects[0].Line != 4 || rejects[0].Reason != models.RejectSpeedFilter {
+		t.Errorf("Expected a speed_filter reject on line 4, got %+v", rejects)
+	}
+}
+
+func TestExternalGroupingBoundsOpenRuns(t *testing.T) {
+	// 500 one-row runs merged four at a time: several passes
+	var emitted []row
+	tempDir := t.TempDir()
+	g := &externalGrouper{
+		out: newAssembler(DeliveryLimits{}, func(rows []row, more bool) error {
+			emitted = append(emitted, rows...)
+			return nil
+		}, nil),
+		tempDir: tempDir,
+		limit:   1,
+		fanIn:   4,
+	}
+	defer g.close()
+
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 500; i++ {
+		r := row{point: models.DeliveryPoint{ID: int64(i * 37 % 50), Timestamp: start.Add(time.Duration(i) * time.Second)}, ordinal: int64(i), record: []string{"x"}}
+		if err := g.add(r); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	if err := g.flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	if g.maxOpen > g.fanIn+1 { // The runs being merged and the one being written
+		t.Errorf("Up to %d run files were open at once, want at most %d", g.maxOpen, g.fanIn+1)
+	}
+	if g.open != 0 {
+		t.Errorf("%d run files left open", g.open)
+	}
+	if len(emitted) != 500 {
+		t.Fatalf("Expected 500 rows, got %d", len(emitted))
+	}
+	for i := 1; i < len(emitted); i++ {
+		if !rowLess(emitted[i-1], emitted[i]) {
+			t.Fatalf("Row %d (id %d, ordinal %d) is out of order after id %d, ordinal %d", i,
+				emitted[i].point.ID, emitted[i].ordinal, emitted[i-1].point.ID, emitted[i-1].ordinal)
+		}
+	}
+
+	g.close()
+	if files, _ := os.ReadDir(tempDir); len(files) != 0 {
+		t.Errorf("Expected every run file to be removed, %d left", len(files))
+	}
+}
+
+func TestEncodeDecodeRow(t *testing.T) {
+	rows := []row{
+		{
+			point: models.DeliveryPoint{
+				ID:        -42,
+				Latitude:  35.6892,
+				Longitude: 51.3890,
+				Timestamp: time.Unix(1609459200, 123456789).UTC(),
+				Accuracy:  12.5,
+				Extra:     map[string]string{"courier": "c-17", "note": ""},
+			},
+			line:    17,
+			ordinal: 15,
+			source:  2,
+			record:  []string{"-42", "35.6892", "51.3890", "1609459200", ""},
+		},
+		{
+			point:  models.DeliveryPoint{ID: 7, Timestamp: time.Unix(-86400, 0).UTC()},
+			record: []string{},
+		},
+	}
+
+	var encoded []byte
+	for _, r := range rows {
+		encoded = encodeRow(encoded, r)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(encoded))
+	header := make([]byte, rowHeaderSize)
+	for i, expected := range rows {
+		result, err := decodeRow(reader, header)
+		if err != nil {
+			t.Fatalf("Row %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Row %d: got %+v, want %+v", i, result, expected)
+		}
+	}
+
+	if _, err := decodeRow(reader, header); err != io.EOF {
+		t.Errorf("Expected EOF after the last row, got %v", err)
+	}
+
+	// Fast-path rows come back with their record split into fields
+	fast := row{point: models.DeliveryPoint{ID: 3}, line: 2, raw: []byte("3,35.6892,,1609459200")}
+	result, err := decodeRow(bufio.NewReader(bytes.NewReader(encodeRow(nil, fast))), header)
+	if err != nil || !reflect.DeepEqual(result.record, []string{"3", "35.6892", "", "1609459200"}) {
+		t.Errorf("Raw row: got %q, %v", result.record, err)
+	}
+	if _, err := decodeRow(bufio.NewReader(bytes.NewReader(encoded[:10])), header); err == nil || err == io.EOF {
+		t.Errorf("Expected a truncation error, got %v", err)
+	}
+}
+
+func TestParseGroupingMode(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      GroupingMode
+		expectedError bool
+	}{
+		{"contiguous", GroupContiguous, false},
+		{"external", GroupExternal, false},
+		{"hash", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseGroupingMode(tt.input)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("ParseGroupingMode(%q) = %v, %v; want %v", tt.input, result, err, tt.expected)
+			}
+		})
+	}
+}
+
+// longDeliveryInput has a five-minute delivery 1 between two short ones
+const longDeliveryInput = `id,lat,lng,timestamp
+1,40.7100,-74.0000,1609459200
+1,40.7101,-74.0000,1609459260
+1,40.7102,-74.0000,1609459320
+1,40.7103,-74.0000,1609459380
+1,40.7104,-74.0000,1609459440
+2,40.7200,-74.0000,1609459200
+2,40.7201,-74.0000,1609459260
+3,40.7300,-74.0000,1609459200`
+
+func TestDeliveryLimitsSplit(t *testing.T) {
+	path := writeTempCSV(t, longDeliveryInput)
+	expected := []struct {
+		id         int64
+		timestamps []int64
+		part       int
+		more       bool
+	}{
+		{1, []int64{1609459200, 1609459260}, 1, true},
+		{1, []int64{1609459260, 1609459320, 1609459380}, 2, true}, // Starts at the previous part's last point
+		{1, []int64{1609459380, 1609459440}, 3, false},
+		{2, []int64{1609459200, 1609459260}, 0, false},
+		{3, []int64{1609459200}, 0, false},
+	}
+
+	for _, grouping := range []GroupingMode{GroupContiguous, GroupExternal} {
+		for _, limits := range []DeliveryLimits{{MaxPoints: 2}, {MaxDuration: time.Minute}} {
+			pointsChan, errChan := ReadAndFilterCSVWithOptions(path, Options{Grouping: grouping, SortBufferRows: 3, Filters: FilterChain{}, Limits: limits})
+			deliveries := collectDeliveries(t, pointsChan, errChan)
+			if len(deliveries) != len(expected) {
+				t.Fatalf("grouping %v, %+v: expected %d deliveries, got %d", grouping, limits, len(expected), len(deliveries))
+			}
+			for i, e := range expected {
+				delivery := deliveries[i]
+				var timestamps []int64
+				for _, point := range delivery.Points {
+					timestamps = append(timestamps, point.Timestamp.Unix())
+				}
+				if delivery.Seq != int64(i) || delivery.Points[0].ID != e.id || delivery.Part != e.part || delivery.More != e.more || !reflect.DeepEqual(timestamps, e.timestamps) {
+					t.Errorf("grouping %v, %+v: delivery %d is id %d part %d more %v at %v, want id %d part %d more %v at %v", grouping, limits, i,
+						delivery.Points[0].ID, delivery.Part, delivery.More, timestamps, e.id, e.part, e.more, e.timestamps)
+				}
+				if delivery.More && delivery.Next.Offset != -1 {
+					t.Errorf("grouping %v, %+v: delivery %d can be resumed part way through", grouping, limits, i)
+				}
+			}
+		}
+	}
+}
+
+func TestDeliveryLimitsQuarantine(t *testing.T) {
+	path := writeTempCSV(t, longDeliveryInput)
+
+	for _, grouping := range []GroupingMode{GroupContiguous, GroupExternal} {
+		var quarantined []models.QuarantinedDelivery
+		pointsChan, errChan := ReadAndFilterCSVWithOptions(path, Options{
+			Grouping:     grouping,
+			Limits:       DeliveryLimits{MaxDuration: 2 * time.Minute, Overflow: OverflowQuarantine},
+			OnQuarantine: func(delivery models.QuarantinedDelivery) { quarantined = append(quarantined, delivery) },
+		})
+		deliveries := collectDeliveries(t, pointsChan, errChan)
+
+		if len(deliveries) != 2 || deliveries[0].Points[0].ID != 2 || deliveries[1].Points[0].ID != 3 {
+			t.Errorf("grouping %v: expected deliveries 2 and 3, got %+v", grouping, deliveries)
+		}
+		expected := []models.QuarantinedDelivery{{
+			File:   path,
+			ID:     1,
+			Line:   2,
+			Rows:   5,
+			Start:  time.Unix(1609459200, 0).UTC(),
+			End:    time.Unix(1609459440, 0).UTC(),
+			Reason: "spans more than 2m0s",
+		}}
+		if !reflect.DeepEqual(quarantined, expected) {
+			t.Errorf("grouping %v: quarantined %+v, want %+v", grouping, quarantined, expected)
+		}
+		if len(deliveries) > 0 && deliveries[0].Next.Quarantined != 1 {
+			t.Errorf("grouping %v: expected the next delivery to count 1 quarantined, got %d", grouping, deliveries[0].Next.Quarantined)
+		}
+	}
+}
+
+func TestReadAndFilterCSVBudget(t *testing.T) {
+	budget := models.NewPointBudget(3)
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(generateCSV(t, 10, 2, 0), Options{Budget: budget})
+
+	first := <-pointsChan
+	if first.Budget != budget {
+		t.Fatalf("Expected deliveries to carry the budget")
+	}
+	select {
+	case delivery := <-pointsChan:
+		t.Errorf("Expected the reader to wait for the budget, got delivery %d", delivery.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	budget.Release(len(first.Points))
+	count := 1
+	for delivery := range pointsChan {
+		budget.Release(len(delivery.Points))
+		count++
+	}
+	for err := range errChan {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if count != 10 {
+		t.Errorf("Expected 10 deliveries, got %d", count)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for input, expected := range map[string]OverflowPolicy{"split": OverflowSplit, "quarantine": OverflowQuarantine} {
+		if result, err := ParseOverflowPolicy(input); err != nil || result != expected {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v; want %v", input, result, err, expected)
+		}
+	}
+	if _, err := ParseOverflowPolicy("truncate"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
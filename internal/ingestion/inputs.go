@@ -0,0 +1,105 @@
+package ingestion
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inputExtensions are the file names picked up from a directory, optionally
+// followed by a compression extension. Plain .json is left out: directories
+// often hold JSON files that are not tracks, such as tariffs.
+var inputExtensions = []string{".csv", ".ndjson", ".jsonl", ".gpx"}
+
+// StdinInput is the input argument that stands for standard input
+const StdinInput = "-"
+
+// ExpandInputs turns command-line input arguments into a list of files. An
+// argument may be a file, a glob pattern or a directory, whose CSV, NDJSON
+// and GPX files (compressed or not) are used in name order without descending into
+// subdirectories. StdinInput is passed through. Files named more than once
+// are read once. Errors wrap ErrUnreadableInput.
+func ExpandInputs(args []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[filepath.Clean(path)] {
+			seen[filepath.Clean(path)] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == StdinInput {
+			add(arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		switch {
+		case err == nil && info.IsDir():
+			matches, err := listInputDir(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrUnreadableInput, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%w: no input files in directory %s", ErrUnreadableInput, arg)
+			}
+			for _, match := range matches {
+				add(match)
+			}
+		case err == nil:
+			add(arg)
+		case strings.ContainsAny(arg, "*?["):
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrUnreadableInput, arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%w: no files match %s", ErrUnreadableInput, arg)
+			}
+			for _, match := range matches { // Glob sorts its results
+				if info, err := os.Stat(match); err == nil && !info.IsDir() {
+					add(match)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrUnreadableInput, err)
+		}
+	}
+	return files, nil
+}
+
+// listInputDir returns the input files directly inside dir, sorted by name
+func listInputDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isInputFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isInputFile(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range compressionExtensions {
+		if strings.HasSuffix(name, ext) {
+			name = strings.TrimSuffix(name, ext)
+			break
+		}
+	}
+	for _, ext := range inputExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package ingestion
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.csv", "a.csv.gz", "c.CSV.zst", "e.gpx", "f.jsonl.bz2", "notes.txt", "tariff.json", "sub/d.csv"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+	}
+	join := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{"File", join("notes.txt"), join("notes.txt")},
+		{"Directory", join(""), join("a.csv.gz", "b.csv", "c.CSV.zst", "e.gpx", "f.jsonl.bz2")},
+		{"Glob", join("*"), join("a.csv.gz", "b.csv", "c.CSV.zst", "e.gpx", "f.jsonl.bz2", "notes.txt", "tariff.json")},
+		{"Standard input", []string{StdinInput, StdinInput}, []string{StdinInput}},
+		{"Files keep argument order", join("b.csv", "sub/d.csv", "a.csv.gz"), join("b.csv", "sub/d.csv", "a.csv.gz")},
+		{"Duplicates", append(join("b.csv"), join("", "./b.csv")...), join("b.csv", "a.csv.gz", "c.CSV.zst", "e.gpx", "f.jsonl.bz2")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExpandInputs(tt.args)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ExpandInputs(%v) = %v, want %v", tt.args, result, tt.expected)
+			}
+		})
+	}
+
+	for _, args := range [][]string{join("missing.csv"), join("*.xml"), join("sub/..", "empty")} {
+		if _, err := ExpandInputs(args); !errors.Is(err, ErrUnreadableInput) {
+			t.Errorf("ExpandInputs(%v): expected ErrUnreadableInput, got %v", args, err)
+		}
+	}
+}
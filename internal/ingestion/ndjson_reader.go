@@ -0,0 +1,119 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"SBCFAA/internal/models"
+)
+
+// ndjsonReader is the rowReader for newline-delimited JSON, one point per
+// line, e.g. {"id_delivery": 1, "lat": 35.7, "lng": 51.4, "timestamp": 1609459200}.
+// Keys are matched like CSV headers, so every column alias works; other keys
+// end up in DeliveryPoint.Extra. Values may be JSON numbers or strings.
+type ndjsonReader struct {
+	lines   *recordReader
+	lookup  map[string]string // lower-case key -> column
+	columns *columnMap        // positions of the values collected by parse
+}
+
+// ndjsonPositions orders the values of one object for columnMap.parse
+var ndjsonPositions = map[string]int{ColumnID: 0, ColumnLat: 1, ColumnLng: 2, ColumnTimestamp: 3, ColumnAccuracy: 4}
+
+func newNDJSONReader(r io.Reader, opts Options) *ndjsonReader {
+	return &ndjsonReader{
+		lines:   newRecordReader(r),
+		lookup:  aliasLookup(opts.ColumnAliases),
+		columns: &columnMap{id: 0, lat: 1, lng: 2, timestamp: 3, accuracy: 4, width: 5, format: opts.TimestampFormat},
+	}
+}
+
+func (n *ndjsonReader) skipTo(offset, line int64) error {
+	return n.lines.skipTo(offset, line)
+}
+
+func (n *ndjsonReader) next() (row, *models.RejectedRow, error) {
+	for {
+		start := n.lines.offset
+		line, err := n.lines.readLine()
+		if err != nil {
+			return row{}, nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		number := n.lines.line
+		record := []string{string(line)}
+		point, err := n.parse(line)
+		if err != nil {
+			return row{}, &models.RejectedRow{Line: number, Reason: models.RejectParseError, Detail: err.Error(), Record: record}, nil
+		}
+		return row{point: point, line: number, offset: start, record: record}, nil, nil
+	}
+}
+
+// parse builds a point from one JSON object
+func (n *ndjsonReader) parse(line []byte) (models.DeliveryPoint, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(line, &object); err != nil {
+		return models.DeliveryPoint{}, err
+	}
+
+	values := make([]string, n.columns.width)
+	keys := make(map[string]string) // column -> key it was read from
+	var extra map[string]string
+	for key, raw := range object {
+		value, err := jsonText(raw)
+		if err != nil {
+			return models.DeliveryPoint{}, fmt.Errorf("key %q: %v", key, err)
+		}
+		column, ok := n.lookup[strings.ToLower(strings.TrimSpace(key))]
+		if !ok {
+			if extra == nil {
+				extra = make(map[string]string)
+			}
+			extra[key] = value
+			continue
+		}
+		if previous, dup := keys[column]; dup {
+			pair := []string{previous, key}
+			sort.Strings(pair) // Map order is random
+			return models.DeliveryPoint{}, fmt.Errorf("keys %q and %q are both %s", pair[0], pair[1], column)
+		}
+		keys[column] = key
+		values[ndjsonPositions[column]] = value
+	}
+
+	for _, column := range requiredColumns {
+		if _, ok := keys[column]; !ok {
+			return models.DeliveryPoint{}, fmt.Errorf("missing key %s", column)
+		}
+	}
+
+	point, err := n.columns.parse(values)
+	if err != nil {
+		return models.DeliveryPoint{}, err
+	}
+	point.Extra = extra
+	return point, nil
+}
+
+// jsonText returns a JSON string's contents, an empty string for null, and
+// any other value as written
+func jsonText(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return "", nil
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,68 @@
+package ingestion
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+	"SBCFAA/pkg/utils"
+)
+
+func TestNDJSONReader(t *testing.T) {
+	input := `{"id_delivery": 1, "lat": 40.7128, "lng": -74.0060, "timestamp": 1609459200, "courier": "c-17"}
+
+{"delivery_id": "2", "latitude": "40.7129", "lon": -74.0061, "time": "2021-01-01T00:01:00Z", "accuracy": null}
+{"id": 3, "lat": 40.7130, "lng": -74.0062, "ts": 1609459320000, "accuracy": 12.5, "tags": ["a", "b"]}
+not json
+{"id": 4, "lat": 40.7130, "lng": -74.0062}
+{"id": 5, "lat": 40.7130, "latitude": 40.7131, "lng": -74.0062, "ts": 1609459320}
+{"id": 6, "lat": "north", "lng": -74.0062, "ts": 1609459320}
+{"trip": 7, "y": 40.7130, "lng": -74.0062, "ts": 1609459320}
+`
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	type result struct {
+		line   int64
+		point  models.DeliveryPoint
+		detail string
+	}
+	expected := []result{
+		{line: 1, point: models.DeliveryPoint{ID: 1, Latitude: 40.7128, Longitude: -74.0060, Timestamp: base, Extra: map[string]string{"courier": "c-17"}}},
+		{line: 3, point: models.DeliveryPoint{ID: 2, Latitude: 40.7129, Longitude: -74.0061, Timestamp: base.Add(time.Minute)}},
+		{line: 4, point: models.DeliveryPoint{ID: 3, Latitude: 40.7130, Longitude: -74.0062, Timestamp: base.Add(2 * time.Minute), Accuracy: 12.5, Extra: map[string]string{"tags": `["a", "b"]`}}},
+		{line: 5, detail: "invalid character 'o' in literal null (expecting 'u')"},
+		{line: 6, detail: "missing key timestamp"},
+		{line: 7, detail: `keys "lat" and "latitude" are both lat`},
+		{line: 8, detail: `strconv.ParseFloat: parsing "north": invalid syntax`},
+		{line: 9, point: models.DeliveryPoint{ID: 7, Latitude: 40.7130, Longitude: -74.0062, Timestamp: base.Add(2 * time.Minute)}},
+	}
+
+	reader := newNDJSONReader(strings.NewReader(input), Options{
+		ColumnAliases:   map[string][]string{ColumnID: {"trip"}, ColumnLat: {"y"}},
+		TimestampFormat: utils.TimestampAuto,
+	})
+	var results []result
+	for {
+		parsed, rejected, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if rejected != nil {
+			if rejected.Reason != models.RejectParseError || len(rejected.Record) != 1 {
+				t.Errorf("Line %d: unexpected reject %+v", rejected.Line, rejected)
+			}
+			results = append(results, result{line: rejected.Line, detail: rejected.Detail})
+			continue
+		}
+		results = append(results, result{line: parsed.line, point: parsed.point})
+	}
+
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Got %+v\nwant %+v", results, expected)
+	}
+}
@@ -0,0 +1,159 @@
+package ingestion
+
+import (
+	"fmt"
+
+	"SBCFAA/internal/models"
+)
+
+// SpeedFilterMode selects the algorithm used to drop GPS outliers
+type SpeedFilterMode int
+
+const (
+	// SpeedFilterTracks splits a delivery into tracks of points that are
+	// reachable from one another within the speed limit and keeps the longest
+	// one. A bad fix, or a burst of them, ends up on a short track of its
+	// own, wherever it occurs in the delivery.
+	SpeedFilterTracks SpeedFilterMode = iota
+
+	// SpeedFilterAnchor compares each point with the last kept point only.
+	// It cannot recover when the first point of a delivery is a bad fix.
+	SpeedFilterAnchor
+)
+
+const (
+	defaultMaxSpeed = 100.0 // km/h
+	maxTracks       = 8     // candidate tracks followed at once per delivery
+)
+
+// ParseSpeedFilterMode converts a command-line value ("tracks" or "anchor") to a SpeedFilterMode
+func ParseSpeedFilterMode(s string) (SpeedFilterMode, error) {
+	switch s {
+	case "tracks":
+		return SpeedFilterTracks, nil
+	case "anchor":
+		return SpeedFilterAnchor, nil
+	}
+	return 0, fmt.Errorf("unknown speed filter %q (want tracks or anchor)", s)
+}
+
+// SpeedFilter drops points implying a speed above MaxSpeed km/h (zero means
+// defaultMaxSpeed) from the points around them
+type SpeedFilter struct {
+	MaxSpeed float64
+	Mode     SpeedFilterMode
+}
+
+func (f SpeedFilter) Filter(points []models.DeliveryPoint, verdicts []Verdict) {
+	maxSpeed := f.MaxSpeed
+	if maxSpeed <= 0 {
+		maxSpeed = defaultMaxSpeed
+	}
+	dropped := speedOutliers(points, maxSpeed, f.Mode)
+	for i, point := range points {
+		if !dropped[i] {
+			continue
+		}
+		detail := "no kept point to compare with"
+		if neighbour, ok := nearestKept(points, dropped, i); ok {
+			detail = fmt.Sprintf("%.1f km/h from nearest kept point", calculateSpeed(neighbour, point))
+		}
+		verdicts[i] = Verdict{models.RejectSpeedFilter, detail}
+	}
+}
+
+// speedOutliers reports which of the time-ordered points to drop because
+// they imply a speed above maxSpeed km/h
+func speedOutliers(points []models.DeliveryPoint, maxSpeed float64, mode SpeedFilterMode) []bool {
+	if mode == SpeedFilterAnchor {
+		return anchorOutliers(points, maxSpeed)
+	}
+	return trackOutliers(points, maxSpeed)
+}
+
+func anchorOutliers(points []models.DeliveryPoint, maxSpeed float64) []bool {
+	dropped := make([]bool, len(points))
+	last := 0
+	for i := 1; i < len(points); i++ {
+		if calculateSpeed(points[last], points[i]) > maxSpeed {
+			dropped[i] = true
+			continue
+		}
+		last = i
+	}
+	return dropped
+}
+
+// track is a run of mutually reachable points, by index
+type track struct {
+	points []int
+}
+
+func (t *track) last() int { return t.points[len(t.points)-1] }
+
+func trackOutliers(points []models.DeliveryPoint, maxSpeed float64) []bool {
+	var tracks []*track
+	for i := range points {
+		// Join the longest track whose last point can reach this one
+		var best *track
+		for _, candidate := range tracks {
+			if calculateSpeed(points[candidate.last()], points[i]) > maxSpeed {
+				continue
+			}
+			if best == nil || len(candidate.points) > len(best.points) {
+				best = candidate
+			}
+		}
+		if best != nil {
+			best.points = append(best.points, i)
+			continue
+		}
+
+		tracks = append(tracks, &track{points: []int{i}})
+		if len(tracks) > maxTracks { // Forget the shortest, oldest track
+			shortest := 0
+			for j, candidate := range tracks[:len(tracks)-1] {
+				if len(candidate.points) < len(tracks[shortest].points) {
+					shortest = j
+				}
+			}
+			tracks = append(tracks[:shortest], tracks[shortest+1:]...)
+		}
+	}
+
+	// Keep the longest track, the earliest one on a tie
+	dropped := make([]bool, len(points))
+	if len(tracks) == 0 {
+		return dropped
+	}
+	winner := tracks[0]
+	for _, candidate := range tracks[1:] {
+		if len(candidate.points) > len(winner.points) ||
+			(len(candidate.points) == len(winner.points) && candidate.points[0] < winner.points[0]) {
+			winner = candidate
+		}
+	}
+	for i := range dropped {
+		dropped[i] = true
+	}
+	for _, i := range winner.points {
+		dropped[i] = false
+	}
+	return dropped
+}
+
+// nearestKept returns the kept point closest before i, or after it if i
+// precedes every kept point; ok is false when nothing was kept
+func nearestKept(points []models.DeliveryPoint, dropped []bool, i int) (models.DeliveryPoint, bool) {
+	for j := i - 1; j >= 0; j-- {
+		if !dropped[j] {
+			return points[j], true
+		}
+	}
+	for j := i + 1; j < len(points); j++ {
+		if !dropped[j] {
+			return points[j], true
+		}
+	}
+	return models.DeliveryPoint{}, false
+}
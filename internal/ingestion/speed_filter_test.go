@@ -0,0 +1,178 @@
+package ingestion
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// trackPoints builds a delivery of one point per minute moving north at about
+// 36 km/h; indexes listed in bad are replaced by a fix 1 degree (~111 km) away
+func trackPoints(count int, bad ...int) []models.DeliveryPoint {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	points := make([]models.DeliveryPoint, count)
+	for i := range points {
+		points[i] = models.DeliveryPoint{
+			ID:        1,
+			Latitude:  35.7000 + float64(i)*0.0054,
+			Longitude: 51.4000,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	for _, i := range bad {
+		points[i].Latitude += 1
+	}
+	return points
+}
+
+// droppedIndexes lists the positions marked as dropped
+func droppedIndexes(dropped []bool) []int {
+	indexes := []int{}
+	for i, d := range dropped {
+		if d {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func TestSpeedOutliers(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   []models.DeliveryPoint
+		mode     SpeedFilterMode
+		expected []int
+	}{
+		{"Clean track", trackPoints(6), SpeedFilterTracks, []int{}},
+		{"Bad first point", trackPoints(6, 0), SpeedFilterTracks, []int{0}},
+		{"Bad last point", trackPoints(6, 5), SpeedFilterTracks, []int{5}},
+		{"Bad point in the middle", trackPoints(6, 3), SpeedFilterTracks, []int{3}},
+		{"Burst of bad points", trackPoints(10, 3, 4, 5), SpeedFilterTracks, []int{3, 4, 5}},
+		{"Burst of bad points at the start", trackPoints(8, 0, 1, 2), SpeedFilterTracks, []int{0, 1, 2}},
+		{"Burst of bad points at the end", trackPoints(8, 5, 6, 7), SpeedFilterTracks, []int{5, 6, 7}},
+		{"Scattered bad points", trackPoints(12, 0, 4, 5, 11), SpeedFilterTracks, []int{0, 4, 5, 11}},
+		{"Single point", trackPoints(1), SpeedFilterTracks, []int{}},
+		{"Two points, second bad", trackPoints(2, 1), SpeedFilterTracks, []int{1}},
+		{"Anchor: bad last point", trackPoints(6, 5), SpeedFilterAnchor, []int{5}},
+		{"Anchor: bad first point drops the rest", trackPoints(4, 0), SpeedFilterAnchor, []int{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := droppedIndexes(speedOutliers(tt.points, defaultMaxSpeed, tt.mode))
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("speedOutliers() dropped %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSpeedOutliersManyBadTracks(t *testing.T) {
+	// Every bad fix lands somewhere new, so each starts its own track and
+	// old ones have to be forgotten
+	points := trackPoints(30)
+	var expected []int
+	for i := 1; i < len(points); i += 3 {
+		points[i].Latitude += float64(i)
+		expected = append(expected, i)
+	}
+
+	result := droppedIndexes(speedOutliers(points, defaultMaxSpeed, SpeedFilterTracks))
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("speedOutliers() dropped %v, want %v", result, expected)
+	}
+}
+
+func TestSpeedOutliersThreshold(t *testing.T) {
+	points := trackPoints(5) // ~36 km/h between points
+	for _, tt := range []struct {
+		maxSpeed float64
+		dropped  int
+	}{
+		{100, 0},
+		{40, 0},
+		{30, 4}, // Every point is its own track; the first one wins the tie
+	} {
+		result := droppedIndexes(speedOutliers(points, tt.maxSpeed, SpeedFilterTracks))
+		if len(result) != tt.dropped {
+			t.Errorf("maxSpeed %v: dropped %v, want %d points", tt.maxSpeed, result, tt.dropped)
+		}
+	}
+}
+
+func TestReadAndFilterCSVMaxSpeed(t *testing.T) {
+	// ~36 km/h between the first two points, ~72 km/h to the third
+	input := `id,lat,lng,timestamp
+1,35.7000,51.4000,1609459200
+1,35.7054,51.4000,1609459260
+1,35.7162,51.4000,1609459320`
+
+	for _, tt := range []struct {
+		maxSpeed float64
+		points   int
+	}{
+		{0, 3}, // default 100 km/h
+		{50, 2},
+	} {
+		pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+			Filters: FilterChain{SpeedFilter{MaxSpeed: tt.maxSpeed}},
+		})
+		deliveries := collectDeliveries(t, pointsChan, errChan)
+		if len(deliveries) != 1 || len(deliveries[0].Points) != tt.points {
+			t.Errorf("MaxSpeed %v: expected 1 delivery with %d points, got %+v", tt.maxSpeed, tt.points, deliveries)
+		}
+	}
+}
+
+func TestReadAndFilterCSVRecoversFromBadFirstPoint(t *testing.T) {
+	input := `id,lat,lng,timestamp
+1,36.7000,51.4000,1609459200
+1,35.7054,51.4000,1609459260
+1,35.7108,51.4000,1609459320
+1,35.7162,51.4000,1609459380`
+
+	var rejects []models.RejectedRow
+	pointsChan, errChan := ReadAndFilterCSVWithOptions(writeTempCSV(t, input), Options{
+		OnReject: func(row models.RejectedRow) { rejects = append(rejects, row) },
+	})
+	deliveries := collectDeliveries(t, pointsChan, errChan)
+
+	if len(deliveries) != 1 || len(deliveries[0].Points) != 3 {
+		t.Fatalf("Expected 1 delivery with 3 points, got %+v", deliveries)
+	}
+	if deliveries[0].Points[0].Latitude != 35.7054 {
+		t.Errorf("Expected the bad first point to be dropped, got %+v", deliveries[0].Points[0])
+	}
+	if len(rejects) != 1 || rejects[0].Line != 2 || rejects[0].Reason != models.RejectSpeedFilter {
+		t.Errorf("Expected a speed_filter reject on line 2, got %+v", rejects)
+	}
+}
+
+func TestParseSpeedFilterMode(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      SpeedFilterMode
+		expectedError bool
+	}{
+		{"tracks", SpeedFilterTracks, false},
+		{"anchor", SpeedFilterAnchor, false},
+		{"kalman", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseSpeedFilterMode(tt.input)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil || result != tt.expected {
+				t.Errorf("ParseSpeedFilterMode(%q) = %v, %v; want %v", tt.input, result, err, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+// Delivery is the group of points sharing an id_delivery, in the order
+// ingestion emitted it. Seq counts deliveries from 0 without gaps.
+type Delivery struct {
+	Seq    int64
+	Points []DeliveryPoint
+
+	// Part numbers the pieces of a delivery that ingestion split because it
+	// outgrew its limits: 0 for a whole delivery, otherwise 1, 2, ... with
+	// consecutive Seqs. Each part after the first starts with the last point
+	// of the one before, and More is set on every part but the last.
+	Part int
+	More bool
+
+	// Next is where reading would resume once this delivery and every one
+	// before it have been handled
+	Next InputPosition
+
+	// Budget, if set, holds len(Points) until the delivery has been priced
+	Budget *PointBudget
+}
+
+// InputPosition is a place in a run's inputs where reading can resume
+type InputPosition struct {
+	Source      int   `json:"source"`      // index of the input; the number of inputs once all are read
+	Offset      int64 `json:"offset"`      // byte offset of a row in the decompressed input; -1 when unknown
+	Line        int64 `json:"line"`        // line number of that row
+	Rejects     int64 `json:"rejects"`     // rows rejected before this position
+	Quarantined int64 `json:"quarantined"` // deliveries quarantined before this position
+}
@@ -7,4 +7,8 @@ type DeliveryPoint struct {
 	Latitude  float64   `csv:"lat"`
 	Longitude float64   `csv:"lng"`
 	Timestamp time.Time `csv:"timestamp"`
+	Accuracy  float64   `csv:"accuracy"` // horizontal accuracy radius in meters; 0 when unknown
+
+	// Extra holds input columns the reader did not recognise, by header name
+	Extra map[string]string `csv:"-"`
 }
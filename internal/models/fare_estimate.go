@@ -1,6 +1,35 @@
 package models
 
 type FareEstimate struct {
-	DeliveryID int64   `csv:"id_delivery"`
-	Fare       float64 `csv:"fare_estimate"`
+	DeliveryID int64         `csv:"id_delivery"`
+	Fare       float64       `csv:"fare_estimate"`
+	Breakdown  FareBreakdown `csv:"-"`
+	Seq        int64         `csv:"-"` // Seq of the delivery this estimate prices
+	Next       InputPosition `csv:"-"` // Next of the delivery this estimate prices
+
+	// Part and More are copied from a Delivery split by ingestion. Only the
+	// estimate of the last part is priced, for the whole delivery; the
+	// others are left empty until the fare calculator joins the parts.
+	Part int  `csv:"-"`
+	More bool `csv:"-"`
+}
+
+// FareBreakdown itemises how a fare estimate was built up. Costs are not
+// rounded; only the final fare is.
+type FareBreakdown struct {
+	FlagCharge         float64 `csv:"flag_charge"`
+	MovingDayKm        float64 `csv:"moving_day_km"`
+	MovingNightKm      float64 `csv:"moving_night_km"`
+	IdleHours          float64 `csv:"idle_hours"`
+	MovingDayCost      float64 `csv:"moving_day_cost"`
+	MovingNightCost    float64 `csv:"moving_night_cost"`
+	IdleCost           float64 `csv:"idle_cost"`
+	MinimumFareApplied bool    `csv:"minimum_fare_applied"`
+	Points             int     `csv:"points"`
+	Segments           int     `csv:"segments"`
+
+	// ZoneSurcharge is the sum of the surcharges of Zones, the priced zones
+	// the delivery passed through, in the order it entered them
+	ZoneSurcharge float64  `csv:"zone_surcharge"`
+	Zones         []string `csv:"zones"`
 }
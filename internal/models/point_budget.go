@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"sync"
+)
+
+// PointBudget caps the points held by deliveries on their way from
+// ingestion to pricing, bounding the memory of the channels between them
+type PointBudget struct {
+	limit int
+
+	mu       sync.Mutex
+	used     int
+	released chan struct{} // closed and replaced on every Release
+}
+
+// NewPointBudget returns a budget of limit points
+func NewPointBudget(limit int) *PointBudget {
+	return &PointBudget{limit: limit, released: make(chan struct{})}
+}
+
+// Acquire blocks until n points fit in the budget, or ctx is done. A
+// delivery larger than the whole budget is let through once nothing else
+// holds any, so that it cannot wait forever. A nil budget never blocks.
+func (b *PointBudget) Acquire(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release returns n points to the budget. It does nothing on a nil budget.
+func (b *PointBudget) Release(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+	b.mu.Unlock()
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// QuarantinedDelivery is a delivery that was dropped whole because it
+// outgrew the ingestion limits, such as a stuck device pinging for hours
+type QuarantinedDelivery struct {
+	File   string    `csv:"file"` // input file of its first row; empty for a single unnamed input
+	ID     int64     `csv:"id_delivery"`
+	Line   int64     `csv:"first_line"`
+	Rows   int64     `csv:"rows"`
+	Start  time.Time `csv:"first_timestamp"` // earliest timestamp among the rows
+	End    time.Time `csv:"last_timestamp"`  // latest timestamp among the rows
+	Reason string    `csv:"reason"`          // the limit it exceeded
+}
@@ -0,0 +1,27 @@
+package models
+
+// RejectReason is a short, stable code explaining why an input row was dropped
+type RejectReason string
+
+const (
+	RejectCSVSyntax       RejectReason = "csv_syntax"         // the row is not valid CSV
+	RejectColumnCount     RejectReason = "column_count"       // the row has the wrong number of columns
+	RejectParseError      RejectReason = "parse_error"        // a field could not be parsed
+	RejectDuplicate       RejectReason = "duplicate"          // same delivery, timestamp and coordinates as an earlier row
+	RejectTimeConflict    RejectReason = "timestamp_conflict" // same delivery and timestamp as an earlier row, other coordinates
+	RejectSpeedFilter     RejectReason = "speed_filter"       // implied speed from the previous point is too high
+	RejectOutOfRange      RejectReason = "out_of_range"       // latitude or longitude outside the valid range
+	RejectNullIsland      RejectReason = "null_island"        // the point is at 0,0, a GPS fix that was never set
+	RejectLowAccuracy     RejectReason = "low_accuracy"       // the reported accuracy radius is too large
+	RejectOutsideGeofence RejectReason = "outside_geofence"   // the point lies outside the configured area
+)
+
+// RejectedRow is an input row that did not make it into a delivery
+type RejectedRow struct {
+	File   string       `csv:"file"` // input file the row came from; empty for a single unnamed input
+	Line   int64        `csv:"line"`
+	Reason RejectReason `csv:"reason"`
+	Detail string       `csv:"detail"`
+	Record []string     `csv:"-"`          // fields parsed from the row, up to any syntax error
+	Raw    string       `csv:"raw_record"` // the row as read, without its line break; if empty, Record joined with commas
+}
@@ -0,0 +1,217 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"SBCFAA/internal/models"
+)
+
+// Files kept next to the output of a checkpointed write until it succeeds
+const (
+	PartialSuffix    = ".partial"    // the output written so far
+	CheckpointSuffix = ".checkpoint" // the last Checkpoint, as JSON
+)
+
+// DefaultCheckpointInterval is used when CheckpointOptions.Interval is zero
+const DefaultCheckpointInterval = time.Minute
+
+// Checkpoint records how far a checkpointed write had got: the rows of every
+// delivery before Next fill the first OutputBytes bytes of the partial
+// output. Inputs and Args are left to the caller, to check that a resumed
+// run continues the same work.
+type Checkpoint struct {
+	Next        models.InputPosition `json:"next"`
+	OutputBytes int64                `json:"output_bytes"`
+	Deliveries  int64                `json:"deliveries"` // rows written after the header
+	Inputs      []InputFile          `json:"inputs,omitempty"`
+	Args        []string             `json:"args,omitempty"`
+	Time        time.Time            `json:"time"`
+}
+
+// InputFile identifies an input file as it was when a checkpoint was taken
+type InputFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// CheckpointOptions makes WriteCSVWithOptions resumable. Estimates must
+// arrive in the order their deliveries were read, so that every delivery
+// before a checkpoint's Next has been written.
+type CheckpointOptions struct {
+	// Interval is the time between checkpoints; zero means DefaultCheckpointInterval
+	Interval time.Duration
+
+	// Resume continues the write that saved this checkpoint: the partial
+	// output is cut back to Resume.OutputBytes and appended to
+	Resume *Checkpoint
+
+	// Save is called with each checkpoint once the output it covers is on disk
+	Save func(Checkpoint) error
+}
+
+// SaveCheckpoint writes checkpoint to filename, replacing it atomically
+func SaveCheckpoint(filename string, checkpoint Checkpoint) (err error) {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// LoadCheckpoint reads a checkpoint saved by SaveCheckpoint
+func LoadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &checkpoint, nil
+}
+
+// writeResumable is WriteCSVWithOptions with opts.Checkpoint set. The rows
+// go straight to filename+PartialSuffix, which is kept on failure and
+// renamed to filename on success.
+func writeResumable(filename string, estimates <-chan models.FareEstimate, opts Options) (err error) {
+	partial := filename + PartialSuffix
+	cp := &checkpointer{interval: opts.Checkpoint.Interval, save: opts.Checkpoint.Save, last: time.Now()}
+	if cp.interval <= 0 {
+		cp.interval = DefaultCheckpointInterval
+	}
+
+	var file *os.File
+	if resume := opts.Checkpoint.Resume; resume != nil {
+		file, err = openPartial(partial, resume.OutputBytes)
+		cp.output = &countingWriter{w: file, n: resume.OutputBytes}
+		cp.rows, cp.resumed = resume.Deliveries, true
+	} else {
+		file, err = os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		cp.output = &countingWriter{w: file}
+	}
+	if err != nil {
+		drain(estimates, opts)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close() // The partial output stays for the next resume
+		}
+	}()
+	cp.file = file
+
+	if err := writeEstimates(cp.output, estimates, opts, cp); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if opts.BeforeCommit != nil {
+		if err := opts.BeforeCommit(); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(partial, filename); err != nil {
+		return err
+	}
+
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// openPartial opens the partial output of an interrupted run, dropping
+// whatever was written after its last checkpoint
+func openPartial(partial string, size int64) (*os.File, error) {
+	file, err := os.OpenFile(partial, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() < size {
+		err = fmt.Errorf("%s is shorter than its checkpoint (%d < %d bytes)", partial, info.Size(), size)
+	}
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// checkpointer takes the checkpoints of a resumable write
+type checkpointer struct {
+	file     *os.File
+	output   *countingWriter
+	interval time.Duration
+	save     func(Checkpoint) error
+	resumed  bool      // the header is already in the partial output
+	rows     int64     // rows written, including before a resume
+	last     time.Time // when the last checkpoint was taken
+}
+
+// add counts estimate's row and reports whether a checkpoint is due after
+// it. Positions inside inputs that cannot resume are skipped.
+func (c *checkpointer) add(estimate models.FareEstimate) bool {
+	c.rows++
+	return estimate.Next.Offset >= 0 && time.Since(c.last) >= c.interval
+}
+
+// take syncs the output, whose rows up to estimate's have been flushed, and
+// saves a checkpoint resuming after estimate
+func (c *checkpointer) take(estimate models.FareEstimate) error {
+	if err := c.file.Sync(); err != nil {
+		return err
+	}
+	c.last = time.Now()
+	return c.save(Checkpoint{Next: estimate.Next, OutputBytes: c.output.n, Deliveries: c.rows, Time: c.last})
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
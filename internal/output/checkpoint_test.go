@@ -0,0 +1,124 @@
+package output
+
+import (
+	"SBCFAA/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sendEstimates returns a closed channel holding estimates
+func sendEstimates(estimates []models.FareEstimate) <-chan models.FareEstimate {
+	estimatesChan := make(chan models.FareEstimate, len(estimates))
+	for _, estimate := range estimates {
+		estimatesChan <- estimate
+	}
+	close(estimatesChan)
+	return estimatesChan
+}
+
+func TestWriteCSVResume(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "out.csv")
+	estimates := []models.FareEstimate{
+		{DeliveryID: 1, Fare: 3.47, Next: models.InputPosition{Offset: 40, Line: 4}},
+		{DeliveryID: 2, Fare: 5.00, Next: models.InputPosition{Offset: 80, Line: 7, Rejects: 1}},
+		{DeliveryID: 3, Fare: 7.25, Next: models.InputPosition{Offset: -1}}, // Not resumable
+		{DeliveryID: 4, Fare: 9.10, Next: models.InputPosition{Source: 1}},
+	}
+
+	// The first run saves a checkpoint after every resumable row, then fails
+	var checkpoints []Checkpoint
+	err := WriteCSVWithOptions(testFile, sendEstimates(estimates), Options{
+		Checkpoint: &CheckpointOptions{
+			Interval: time.Nanosecond,
+			Save: func(checkpoint Checkpoint) error {
+				checkpoints = append(checkpoints, checkpoint)
+				return nil
+			},
+		},
+		BeforeCommit: func() error { return errors.New("interrupted") },
+	})
+	if err == nil {
+		t.Fatalf("Expected the first run to fail")
+	}
+	if len(checkpoints) != 3 {
+		t.Fatalf("Expected 3 checkpoints, got %d", len(checkpoints))
+	}
+	second := checkpoints[1]
+	if second.Next != estimates[1].Next || second.Deliveries != 2 || second.OutputBytes != int64(len("id_delivery,fare_estimate\n1,3.47\n2,5.00\n")) {
+		t.Errorf("Unexpected second checkpoint %+v", second)
+	}
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no output after a failed run, got %v", err)
+	}
+
+	// Resuming from the second checkpoint drops the rows written after it
+	err = WriteCSVWithOptions(testFile, sendEstimates(estimates[2:]), Options{
+		Checkpoint: &CheckpointOptions{Resume: &second, Save: func(Checkpoint) error { return nil }},
+	})
+	if err != nil {
+		t.Fatalf("Resumed write failed: %v", err)
+	}
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if expected := "id_delivery,fare_estimate\n1,3.47\n2,5.00\n3,7.25\n4,9.10\n"; string(content) != expected {
+		t.Errorf("Expected file content to be '%s', got '%s'", expected, string(content))
+	}
+	if _, err := os.Stat(testFile + PartialSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial output to be renamed, got %v", err)
+	}
+}
+
+func TestWriteCSVResumeShortPartial(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(testFile+PartialSuffix, []byte("id_delivery,fare_estimate\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial output: %v", err)
+	}
+
+	estimatesChan := sendEstimates([]models.FareEstimate{{DeliveryID: 1, Fare: 3.47}})
+	err := WriteCSVWithOptions(testFile, estimatesChan, Options{
+		Checkpoint: &CheckpointOptions{Resume: &Checkpoint{OutputBytes: 100}},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a partial output shorter than its checkpoint")
+	}
+	if len(estimatesChan) != 0 {
+		t.Errorf("Expected estimates to be drained after the error")
+	}
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "out.csv"+CheckpointSuffix)
+	checkpoint := Checkpoint{
+		Next:        models.InputPosition{Source: 1, Offset: 1234, Line: 56, Rejects: 2},
+		OutputBytes: 789,
+		Deliveries:  10,
+		Inputs:      []InputFile{{Name: "trips.csv", Size: 5678, ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		Args:        []string{"-checkpoint", "5m", "trips.csv"},
+		Time:        time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC),
+	}
+
+	for i := 0; i < 2; i++ { // The second save replaces the first
+		if err := SaveCheckpoint(testFile, checkpoint); err != nil {
+			t.Fatalf("SaveCheckpoint failed: %v", err)
+		}
+	}
+	loaded, err := LoadCheckpoint(testFile)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint failed: %v", err)
+	}
+	if !reflect.DeepEqual(*loaded, checkpoint) {
+		t.Errorf("Expected %+v, got %+v", checkpoint, *loaded)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(testFile))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the checkpoint file, found %d files", len(entries))
+	}
+}
@@ -3,57 +3,211 @@ package output
 import (
 	"SBCFAA/internal/models"
 	"encoding/csv"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
-	"sync"
+	"strings"
 )
 
 const bufferSize = 1000 //change buffer size
 
+// Options controls what WriteCSVWithOptions writes and how it commits the file
+type Options struct {
+	Breakdown bool // append the fare breakdown columns after fare_estimate
+
+	// KeepPrevious leaves an existing output file in place when the write
+	// fails. By default it is removed so stale results are not mistaken for
+	// the output of the failed run.
+	KeepPrevious bool
+
+	// BeforeCommit, if set, is called once every estimate is written and
+	// synced, just before the file is renamed into place. Returning an error
+	// fails the write, e.g. when an upstream stage stopped early.
+	BeforeCommit func() error
+
+	// Checkpoint, if set, makes the write resumable; see CheckpointOptions
+	Checkpoint *CheckpointOptions
+
+	// Cancel, if set, is called when writing fails, before the rest of
+	// estimates is drained, so upstream stages stop instead of reading and
+	// pricing the remaining input
+	Cancel func()
+}
+
+var (
+	header          = []string{"id_delivery", "fare_estimate"}
+	breakdownHeader = []string{
+		"flag_charge", "moving_day_km", "moving_night_km", "idle_hours",
+		"moving_day_cost", "moving_night_cost", "idle_cost",
+		"minimum_fare_applied", "points", "segments",
+		"zone_surcharge", "zones",
+	}
+)
+
 func WriteCSV(filename string, estimates <-chan models.FareEstimate) error {
-	file, err := os.Create(filename)
+	return WriteCSVWithOptions(filename, estimates, Options{})
+}
+
+// WriteCSVWithOptions writes estimates to filename with the columns selected
+// by opts. The data goes to a temporary file in the same directory, which is
+// synced and renamed over filename only on success, so readers never see a
+// half-written file. With opts.Checkpoint, that file is filename+PartialSuffix
+// and it is kept on failure.
+//
+// It returns the first create, write, flush, sync, close or rename error; in
+// that case the temporary file is removed, opts.Cancel is called and the
+// rest of estimates is drained so upstream goroutines can finish.
+func WriteCSVWithOptions(filename string, estimates <-chan models.FareEstimate, opts Options) (err error) {
+	defer func() {
+		if err != nil && !opts.KeepPrevious {
+			os.Remove(filename)
+		}
+	}()
+
+	if opts.Checkpoint != nil {
+		return writeResumable(filename, estimates, opts)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
+		drain(estimates, opts)
 		return err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name()) // Never leave a truncated file behind
+		}
+	}()
 
-	if err := writer.Write([]string{"id_delivery", "fare_estimate"}); err != nil { // Write header
+	if err := writeEstimates(file, estimates, opts, nil); err != nil {
+		return err
+	}
+	if err := file.Chmod(0o644); err != nil { // CreateTemp uses 0600
+		return err
+	}
+	if err := file.Sync(); err != nil {
 		return err
 	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if opts.BeforeCommit != nil {
+		if err := opts.BeforeCommit(); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+
+	syncDir(filepath.Dir(filename))
+	return nil
+}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+// Write writes estimates to w as CSV with the columns selected by opts, for
+// output that is not a file, such as standard output. Unlike a file, w cannot
+// be rolled back: a BeforeCommit error is returned after everything has been
+// written. On a write error opts.Cancel is called and the rest of estimates
+// is drained.
+func Write(w io.Writer, estimates <-chan models.FareEstimate, opts Options) error {
+	if err := writeEstimates(w, estimates, opts, nil); err != nil {
+		return err
+	}
+	if opts.BeforeCommit != nil {
+		return opts.BeforeCommit()
+	}
+	return nil
+}
 
-	go func() {
-		defer wg.Done()
-		buffer := make([][]string, 0, bufferSize)
+// syncDir makes a rename in dir durable. Failures are ignored since not every
+// platform supports syncing a directory.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
 
-		for estimate := range estimates {
-			buffer = append(buffer, []string{
-				strconv.FormatInt(estimate.DeliveryID, 10),
-				strconv.FormatFloat(estimate.Fare, 'f', 2, 64),
-			})
+// writeEstimates writes the header and every estimate to w as CSV. cp, if
+// not nil, takes checkpoints along the way; a resumed write has no header.
+func writeEstimates(w io.Writer, estimates <-chan models.FareEstimate, opts Options, cp *checkpointer) error {
+	writer := csv.NewWriter(w)
 
-			if len(buffer) >= bufferSize {
-				if err := writer.WriteAll(buffer); err != nil {
-					// Log the error, but continue processing
-					log.Printf("Error writing to CSV: %v", err)
-				}
-				buffer = buffer[:0] // Clear the buffer
-			}
+	columns := header
+	if opts.Breakdown {
+		columns = append(append([]string{}, header...), breakdownHeader...)
+	}
+	if cp == nil || !cp.resumed {
+		if err := writer.Write(columns); err != nil { // Write header
+			drain(estimates, opts)
+			return err
 		}
+	}
 
-		if len(buffer) > 0 { // Write any remaining records
+	buffer := make([][]string, 0, bufferSize)
+	for estimate := range estimates {
+		buffer = append(buffer, formatEstimate(estimate, opts))
+		checkpoint := cp != nil && cp.add(estimate)
+
+		if len(buffer) >= bufferSize || checkpoint {
 			if err := writer.WriteAll(buffer); err != nil {
-				log.Printf("Error writing final buffer to CSV: %v", err)
+				drain(estimates, opts)
+				return err
 			}
+			buffer = buffer[:0] // Clear the buffer
 		}
-	}()
+		if checkpoint {
+			if err := cp.take(estimate); err != nil {
+				drain(estimates, opts)
+				return err
+			}
+		}
+	}
 
-	wg.Wait()
-	return nil
+	if len(buffer) > 0 { // Write any remaining records
+		if err := writer.WriteAll(buffer); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// drain cancels upstream through opts.Cancel and discards the remaining
+// estimates, so upstream goroutines can finish
+func drain(estimates <-chan models.FareEstimate, opts Options) {
+	if opts.Cancel != nil {
+		opts.Cancel()
+	}
+	for range estimates {
+	}
+}
+
+func formatEstimate(estimate models.FareEstimate, opts Options) []string {
+	record := []string{
+		strconv.FormatInt(estimate.DeliveryID, 10),
+		strconv.FormatFloat(estimate.Fare, 'f', 2, 64),
+	}
+	if !opts.Breakdown {
+		return record
+	}
+
+	b := estimate.Breakdown
+	return append(record,
+		strconv.FormatFloat(b.FlagCharge, 'f', 2, 64),
+		strconv.FormatFloat(b.MovingDayKm, 'f', 3, 64),
+		strconv.FormatFloat(b.MovingNightKm, 'f', 3, 64),
+		strconv.FormatFloat(b.IdleHours, 'f', 4, 64),
+		strconv.FormatFloat(b.MovingDayCost, 'f', 2, 64),
+		strconv.FormatFloat(b.MovingNightCost, 'f', 2, 64),
+		strconv.FormatFloat(b.IdleCost, 'f', 2, 64),
+		strconv.FormatBool(b.MinimumFareApplied),
+		strconv.Itoa(b.Points),
+		strconv.Itoa(b.Segments),
+		strconv.FormatFloat(b.ZoneSurcharge, 'f', 2, 64),
+		strings.Join(b.Zones, ";"),
+	)
 }
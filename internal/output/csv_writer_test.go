@@ -3,6 +3,7 @@ package output
 import (
 	"SBCFAA/internal/models"
 	"bufio"
+	"errors"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -105,6 +106,211 @@ func TestWriteCSVEmptyInput(t *testing.T) {
 	}
 }
 
+func TestWriteCSVWithBreakdown(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_output_breakdown.csv")
+
+	estimatesChan := make(chan models.FareEstimate, 1)
+	estimatesChan <- models.FareEstimate{
+		DeliveryID: 7,
+		Fare:       12.34,
+		Breakdown: models.FareBreakdown{
+			FlagCharge:      1.30,
+			MovingDayKm:     5.0037,
+			MovingNightKm:   1.25,
+			IdleHours:       0.5,
+			MovingDayCost:   3.702738,
+			MovingNightCost: 1.6251,
+			IdleCost:        5.95,
+			Points:          12,
+			Segments:        11,
+			ZoneSurcharge:   4.5,
+			Zones:           []string{"Airport", "Old Town"},
+		},
+	}
+	close(estimatesChan)
+
+	if err := WriteCSVWithOptions(testFile, estimatesChan, Options{Breakdown: true}); err != nil {
+		t.Fatalf("WriteCSVWithOptions failed: %v", err)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	expectedContent := "id_delivery,fare_estimate,flag_charge,moving_day_km,moving_night_km,idle_hours," +
+		"moving_day_cost,moving_night_cost,idle_cost,minimum_fare_applied,points,segments,zone_surcharge,zones\n" +
+		"7,12.34,1.30,5.004,1.250,0.5000,3.70,1.63,5.95,false,12,11,4.50,Airport;Old Town\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+}
+
+// failingWriter accepts limit bytes and then fails every write
+type failingWriter struct {
+	limit int
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errDiskFull
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteEstimatesPropagatesWriteError(t *testing.T) {
+	// The producer stands in for the pipeline: it stops only once cancelled
+	estimatesChan := make(chan models.FareEstimate)
+	stop := make(chan struct{})
+	sent := 0
+	go func() {
+		defer close(estimatesChan)
+		for {
+			select {
+			case estimatesChan <- models.FareEstimate{DeliveryID: int64(sent), Fare: 3.47}:
+				sent++
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	cancelled := 0
+	cancel := func() {
+		if cancelled++; cancelled == 1 {
+			close(stop)
+		}
+	}
+	err := writeEstimates(&failingWriter{limit: 1024}, estimatesChan, Options{Cancel: cancel}, nil)
+	if !errors.Is(err, errDiskFull) {
+		t.Errorf("Expected %v, got %v", errDiskFull, err)
+	}
+	if cancelled != 1 {
+		t.Errorf("Expected Cancel to be called once, got %d", cancelled)
+	}
+
+	// The producer must not be left blocked on the channel
+	if _, open := <-estimatesChan; open {
+		t.Errorf("Expected estimates to be drained after the error")
+	}
+	if sent > 2*bufferSize {
+		t.Errorf("Expected the write error to stop the producer, but it sent %d estimates", sent)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	estimatesChan := make(chan models.FareEstimate, 2)
+	estimatesChan <- models.FareEstimate{DeliveryID: 1, Fare: 10.50}
+	estimatesChan <- models.FareEstimate{DeliveryID: 2, Fare: 15.75}
+	close(estimatesChan)
+
+	// A stream cannot be rolled back: the data is written, then the error reported
+	errUpstream := errors.New("upstream failed")
+	var out strings.Builder
+	err := Write(&out, estimatesChan, Options{BeforeCommit: func() error { return errUpstream }})
+	if !errors.Is(err, errUpstream) {
+		t.Errorf("Expected %v, got %v", errUpstream, err)
+	}
+	if expected := "id_delivery,fare_estimate\n1,10.50\n2,15.75\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestWriteCSVRemovesPartialOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "out.csv")
+
+	estimatesChan := make(chan models.FareEstimate, 1)
+	estimatesChan <- models.FareEstimate{DeliveryID: 1, Fare: 3.47}
+	close(estimatesChan)
+
+	errUpstream := errors.New("upstream failed")
+	err := WriteCSVWithOptions(testFile, estimatesChan, Options{
+		BeforeCommit: func() error { return errUpstream },
+	})
+	if !errors.Is(err, errUpstream) {
+		t.Fatalf("Expected %v, got %v", errUpstream, err)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read temp dir: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Expected no files after a failed write, found %s", entry.Name())
+	}
+}
+
+func TestWriteCSVPreviousOutput(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepPrevious bool
+		fail         bool
+		expected     string // "" means the file must not exist
+	}{
+		{"Success replaces previous", false, false, "id_delivery,fare_estimate\n2,4.00\n"},
+		{"Failure removes previous", false, true, ""},
+		{"Failure keeps previous", true, true, "previous\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			testFile := filepath.Join(tempDir, "out.csv")
+			if err := os.WriteFile(testFile, []byte("previous\n"), 0o644); err != nil {
+				t.Fatalf("Failed to write previous output: %v", err)
+			}
+
+			estimatesChan := make(chan models.FareEstimate, 1)
+			estimatesChan <- models.FareEstimate{DeliveryID: 2, Fare: 4}
+			close(estimatesChan)
+
+			opts := Options{KeepPrevious: tt.keepPrevious}
+			if tt.fail {
+				opts.BeforeCommit = func() error { return errors.New("upstream failed") }
+			}
+			err := WriteCSVWithOptions(testFile, estimatesChan, opts)
+			if tt.fail != (err != nil) {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+
+			content, err := os.ReadFile(testFile)
+			if tt.expected == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("Expected output to be removed, got %q (%v)", content, err)
+				}
+			} else if string(content) != tt.expected {
+				t.Errorf("Expected file content to be '%s', got '%s'", tt.expected, string(content))
+			}
+
+			entries, _ := os.ReadDir(tempDir)
+			for _, entry := range entries {
+				if entry.Name() != "out.csv" {
+					t.Errorf("Unexpected leftover file %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCSVCreateError(t *testing.T) {
+	estimatesChan := make(chan models.FareEstimate, 1)
+	estimatesChan <- models.FareEstimate{DeliveryID: 1, Fare: 3.47}
+	close(estimatesChan)
+
+	if err := WriteCSV(filepath.Join(t.TempDir(), "missing", "out.csv"), estimatesChan); err == nil {
+		t.Errorf("Expected an error for a missing directory, but got none")
+	}
+	if len(estimatesChan) != 0 {
+		t.Errorf("Expected estimates to be drained after the error")
+	}
+}
+
 func TestWriteCSVLargeDataset(t *testing.T) {
 	// Define the size of the large dataset
 	const datasetSize = 1000000 // 1 million records
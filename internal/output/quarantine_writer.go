@@ -0,0 +1,82 @@
+package output
+
+import (
+	"SBCFAA/internal/models"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+// QuarantineWriter streams quarantined deliveries to a CSV file with the
+// columns id_delivery,first_line,rows,first_timestamp,last_timestamp,reason,
+// preceded by file when RejectsOptions.File is set
+type QuarantineWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	opts   RejectsOptions
+}
+
+// CreateQuarantineWriter creates filename and writes the header row for opts
+func CreateQuarantineWriter(filename string, opts RejectsOptions) (*QuarantineWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{"id_delivery", "first_line", "rows", "first_timestamp", "last_timestamp", "reason"}
+	if opts.File {
+		header = append([]string{"file"}, header...)
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &QuarantineWriter{file: file, writer: writer, opts: opts}, nil
+}
+
+// ResumeQuarantineWriter reopens a quarantine report left by an interrupted
+// run, keeps its header and first rows deliveries, and appends after them
+func ResumeQuarantineWriter(filename string, opts RejectsOptions, rows int64) (*QuarantineWriter, error) {
+	file, err := reopenCSV(filename, rows)
+	if err != nil {
+		return nil, err
+	}
+	return &QuarantineWriter{file: file, writer: csv.NewWriter(file), opts: opts}, nil
+}
+
+// Write appends one quarantined delivery; timestamps are written in RFC 3339
+func (w *QuarantineWriter) Write(delivery models.QuarantinedDelivery) error {
+	record := []string{
+		strconv.FormatInt(delivery.ID, 10),
+		strconv.FormatInt(delivery.Line, 10),
+		strconv.FormatInt(delivery.Rows, 10),
+		delivery.Start.UTC().Format(time.RFC3339Nano),
+		delivery.End.UTC().Format(time.RFC3339Nano),
+		delivery.Reason,
+	}
+	if w.opts.File {
+		record = append([]string{delivery.File}, record...)
+	}
+	return w.writer.Write(record)
+}
+
+// Sync flushes buffered rows and commits the file to disk
+func (w *QuarantineWriter) Sync() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close flushes buffered rows and closes the file
+func (w *QuarantineWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
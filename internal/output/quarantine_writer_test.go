@@ -0,0 +1,60 @@
+package output
+
+import (
+	"SBCFAA/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuarantineWriter(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "quarantine.csv")
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	deliveries := []models.QuarantinedDelivery{
+		{File: "day1.csv", ID: 7, Line: 2, Rows: 90000, Start: start, End: start.Add(26 * time.Hour), Reason: "spans more than 12h0m0s"},
+		{File: "day2.csv", ID: 9, Line: 15, Rows: 100001, Start: start, End: start.Add(time.Hour), Reason: "more than 100000 points"},
+	}
+
+	writer, err := CreateQuarantineWriter(testFile, RejectsOptions{File: true})
+	if err != nil {
+		t.Fatalf("CreateQuarantineWriter failed: %v", err)
+	}
+	for _, delivery := range deliveries {
+		if err := writer.Write(delivery); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read quarantine file: %v", err)
+	}
+	expectedContent := "file,id_delivery,first_line,rows,first_timestamp,last_timestamp,reason\n" +
+		"day1.csv,7,2,90000,2021-01-01T00:00:00Z,2021-01-02T02:00:00Z,spans more than 12h0m0s\n" +
+		"day2.csv,9,15,100001,2021-01-01T00:00:00Z,2021-01-01T01:00:00Z,more than 100000 points\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+
+	// Resuming after the first delivery drops the second
+	writer, err = ResumeQuarantineWriter(testFile, RejectsOptions{File: true}, 1)
+	if err != nil {
+		t.Fatalf("ResumeQuarantineWriter failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	content, err = os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read quarantine file: %v", err)
+	}
+	expectedContent = "file,id_delivery,first_line,rows,first_timestamp,last_timestamp,reason\n" +
+		"day1.csv,7,2,90000,2021-01-01T00:00:00Z,2021-01-02T02:00:00Z,spans more than 12h0m0s\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+}
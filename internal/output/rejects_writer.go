@@ -0,0 +1,125 @@
+package output
+
+import (
+	"SBCFAA/internal/models"
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RejectsWriter streams rejected input rows to a CSV file with the columns
+// line,reason,detail,raw_record, preceded by file when RejectsOptions.File is set
+type RejectsWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	opts   RejectsOptions
+}
+
+// RejectsOptions tunes CreateRejectsWriterWithOptions
+type RejectsOptions struct {
+	File bool // Add a leading file column, for runs with several inputs
+}
+
+// CreateRejectsWriter creates filename and writes the header row
+func CreateRejectsWriter(filename string) (*RejectsWriter, error) {
+	return CreateRejectsWriterWithOptions(filename, RejectsOptions{})
+}
+
+// CreateRejectsWriterWithOptions creates filename and writes the header row for opts
+func CreateRejectsWriterWithOptions(filename string, opts RejectsOptions) (*RejectsWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{"line", "reason", "detail", "raw_record"}
+	if opts.File {
+		header = append([]string{"file"}, header...)
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &RejectsWriter{file: file, writer: writer, opts: opts}, nil
+}
+
+// ResumeRejectsWriter reopens a rejects file left by an interrupted run,
+// keeps its header and first rows rows, and appends after them
+func ResumeRejectsWriter(filename string, opts RejectsOptions, rows int64) (*RejectsWriter, error) {
+	file, err := reopenCSV(filename, rows)
+	if err != nil {
+		return nil, err
+	}
+	return &RejectsWriter{file: file, writer: csv.NewWriter(file), opts: opts}, nil
+}
+
+// reopenCSV opens a CSV report for appending after its header and first
+// rows rows, dropping the rest
+func reopenCSV(filename string, rows int64) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	for i := int64(0); i <= rows; i++ { // The header, then the rows to keep
+		if _, err := reader.Read(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s has fewer than %d rows: %v", filename, rows, err)
+		}
+	}
+	offset := reader.InputOffset()
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Write appends one rejected row with its raw record as read, or its fields
+// re-joined with commas when that is not known
+func (w *RejectsWriter) Write(row models.RejectedRow) error {
+	raw := row.Raw
+	if raw == "" {
+		raw = strings.Join(row.Record, ",")
+	}
+	record := []string{
+		strconv.FormatInt(row.Line, 10),
+		string(row.Reason),
+		row.Detail,
+		raw,
+	}
+	if w.opts.File {
+		record = append([]string{row.File}, record...)
+	}
+	return w.writer.Write(record)
+}
+
+// Sync flushes buffered rows and commits the file to disk
+func (w *RejectsWriter) Sync() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close flushes buffered rows and closes the file
+func (w *RejectsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
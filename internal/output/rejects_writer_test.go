@@ -0,0 +1,111 @@
+package output
+
+import (
+	"SBCFAA/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRejectsWriter(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "rejects.csv")
+
+	writer, err := CreateRejectsWriter(testFile)
+	if err != nil {
+		t.Fatalf("CreateRejectsWriter failed: %v", err)
+	}
+
+	rows := []models.RejectedRow{
+		{Line: 3, Reason: models.RejectParseError, Detail: `strconv.ParseFloat: parsing "bad": invalid syntax`, Record: []string{"1", "bad", "-74.0060", "1609459200"}},
+		{Line: 7, Reason: models.RejectColumnCount, Detail: "invalid record length: got 3 columns, want 4", Record: []string{"2", "40.7", "-74.0"}},
+		{Line: 9, Reason: models.RejectSpeedFilter, Detail: "612.3 km/h from nearest kept point", Record: []string{"2", "50.7", "-84.0", "1609459260"}},
+		{Line: 10, Reason: models.RejectParseError, Detail: "bad latitude", Record: []string{"2", "50,7", "-84.0", "1609459320"}, Raw: `2,"50,7",-84.0,1609459320`},
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read rejects file: %v", err)
+	}
+
+	expectedContent := "line,reason,detail,raw_record\n" +
+		`3,parse_error,"strconv.ParseFloat: parsing ""bad"": invalid syntax","1,bad,-74.0060,1609459200"` + "\n" +
+		`7,column_count,"invalid record length: got 3 columns, want 4","2,40.7,-74.0"` + "\n" +
+		`9,speed_filter,612.3 km/h from nearest kept point,"2,50.7,-84.0,1609459260"` + "\n" +
+		`10,parse_error,bad latitude,"2,""50,7"",-84.0,1609459320"` + "\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+}
+
+func TestRejectsWriterFileColumn(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "rejects.csv")
+
+	writer, err := CreateRejectsWriterWithOptions(testFile, RejectsOptions{File: true})
+	if err != nil {
+		t.Fatalf("CreateRejectsWriterWithOptions failed: %v", err)
+	}
+	row := models.RejectedRow{File: "day2.csv", Line: 4, Reason: models.RejectDuplicate, Detail: "duplicate", Record: []string{"1", "40.7", "-74.0", "1609459200"}}
+	if err := writer.Write(row); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read rejects file: %v", err)
+	}
+	expectedContent := "file,line,reason,detail,raw_record\n" +
+		`day2.csv,4,duplicate,duplicate,"1,40.7,-74.0,1609459200"` + "\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+}
+
+func TestResumeRejectsWriter(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "rejects.csv")
+	previous := "line,reason,detail,raw_record\n" +
+		`3,parse_error,bad,"1,bad,-74.0060,1609459200"` + "\n" +
+		`7,column_count,"got 3 columns, want 4","2,40.7,-74.0"` + "\n" +
+		`9,duplicate,written after the checkpoint,"3,40.7,-74.0,1609459260"` + "\n"
+	if err := os.WriteFile(testFile, []byte(previous), 0o644); err != nil {
+		t.Fatalf("Failed to write previous rejects: %v", err)
+	}
+
+	writer, err := ResumeRejectsWriter(testFile, RejectsOptions{}, 2)
+	if err != nil {
+		t.Fatalf("ResumeRejectsWriter failed: %v", err)
+	}
+	row := models.RejectedRow{Line: 12, Reason: models.RejectDuplicate, Detail: "duplicate", Record: []string{"3", "40.7", "-74.0", "1609459260"}}
+	if err := writer.Write(row); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	content, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read rejects file: %v", err)
+	}
+	expectedContent := "line,reason,detail,raw_record\n" +
+		`3,parse_error,bad,"1,bad,-74.0060,1609459200"` + "\n" +
+		`7,column_count,"got 3 columns, want 4","2,40.7,-74.0"` + "\n" +
+		`12,duplicate,duplicate,"3,40.7,-74.0,1609459260"` + "\n"
+	if string(content) != expectedContent {
+		t.Errorf("Expected file content to be '%s', got '%s'", expectedContent, string(content))
+	}
+
+	if _, err := ResumeRejectsWriter(testFile, RejectsOptions{}, 5); err == nil {
+		t.Errorf("Expected an error when the file has fewer rows than the checkpoint")
+	}
+}
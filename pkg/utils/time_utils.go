@@ -1,18 +1,177 @@
 package utils
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// IsNightTime checks if the given time is within night hours (00:00 to 05:00)
+// IsNightTime checks if the given time is within the default night hours
+// (00:00 to 05:00 UTC). Tariffs with their own window or time zone use
+// Tariff.isNightTime in the fare package instead.
 func IsNightTime(t time.Time) bool {
-	hour := t.Hour()
+	hour := t.UTC().Hour()
 	return hour >= 0 && hour < 5
 }
 
-// ParseTimestamp converts a Unix timestamp to a time.Time
+// ParseTimestamp converts a Unix timestamp to a UTC time.Time
 func ParseTimestamp(timestamp int64) time.Time {
-	return time.Unix(timestamp, 0)
+	return time.Unix(timestamp, 0).UTC()
+}
+
+// TimestampFormat selects how timestamp strings are interpreted
+type TimestampFormat int
+
+const (
+	// TimestampAuto accepts RFC 3339 strings and Unix numbers, choosing the
+	// unit of a number from its magnitude: below 1e11 seconds, below 1e14
+	// milliseconds, below 1e17 microseconds, otherwise nanoseconds.
+	TimestampAuto TimestampFormat = iota
+	TimestampUnix                 // seconds
+	TimestampUnixMilli
+	TimestampUnixMicro
+	TimestampUnixNano
+	TimestampRFC3339 // e.g. 2021-01-01T03:30:00.250+03:30; a space may replace the T
+)
+
+var timestampFormatNames = map[string]TimestampFormat{
+	"auto":    TimestampAuto,
+	"unix":    TimestampUnix,
+	"unix_ms": TimestampUnixMilli,
+	"unix_us": TimestampUnixMicro,
+	"unix_ns": TimestampUnixNano,
+	"rfc3339": TimestampRFC3339,
+}
+
+// ParseTimestampFormat converts a command-line value (auto, unix, unix_ms,
+// unix_us, unix_ns or rfc3339) to a TimestampFormat
+func ParseTimestampFormat(s string) (TimestampFormat, error) {
+	if format, ok := timestampFormatNames[s]; ok {
+		return format, nil
+	}
+	return 0, fmt.Errorf("unknown timestamp format %q (want auto, unix, unix_ms, unix_us, unix_ns or rfc3339)", s)
+}
+
+// nanosPerUnit is the length of one unit of each Unix format, in nanoseconds
+var nanosPerUnit = map[TimestampFormat]int64{
+	TimestampUnix:      1e9,
+	TimestampUnixMilli: 1e6,
+	TimestampUnixMicro: 1e3,
+	TimestampUnixNano:  1,
+}
+
+// ParseTimestampString parses s in the given format and returns it in UTC.
+// Unix numbers may have a fractional part, which is kept to the nanosecond.
+func ParseTimestampString(s string, format TimestampFormat) (time.Time, error) {
+	if format == TimestampRFC3339 || (format == TimestampAuto && strings.ContainsAny(s, "-:TZ") && !isUnixNumber(s)) {
+		return parseRFC3339(s)
+	}
+
+	intPart, fraction, negative, err := splitDecimal(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if format == TimestampAuto {
+		format = unixFormatFor(intPart)
+	}
+	unit := nanosPerUnit[format]
+	unitsPerSecond := int64(1e9) / unit
+
+	// Keep as many fractional digits as fit in a nanosecond
+	digits := 0
+	for n := unit; n > 1; n /= 10 {
+		digits++
+	}
+	if len(fraction) > digits {
+		fraction = fraction[:digits]
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+	var fractionNanos int64
+	if fraction != "" {
+		fractionNanos, _ = strconv.ParseInt(fraction, 10, 64) // Digits only, checked by splitDecimal
+	}
+
+	seconds := intPart / unitsPerSecond
+	nanos := (intPart%unitsPerSecond)*unit + fractionNanos
+	if negative {
+		seconds, nanos = -seconds, -nanos
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// ParseTimestampBytes is ParseTimestampString for a byte slice. Whole Unix
+// numbers are parsed without allocating.
+func ParseTimestampBytes(b []byte, format TimestampFormat) (time.Time, error) {
+	if format == TimestampRFC3339 || len(b) == 0 || len(b) > 18 { // 18 digits cannot overflow
+		return ParseTimestampString(string(b), format)
+	}
+	var value int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return ParseTimestampString(string(b), format)
+		}
+		value = value*10 + int64(c-'0')
+	}
+	if format == TimestampAuto {
+		format = unixFormatFor(value)
+	}
+	unit := nanosPerUnit[format]
+	unitsPerSecond := int64(1e9) / unit
+	return time.Unix(value/unitsPerSecond, (value%unitsPerSecond)*unit).UTC(), nil
+}
+
+func parseRFC3339(s string) (time.Time, error) {
+	if len(s) > 10 && s[10] == ' ' {
+		s = s[:10] + "T" + s[11:]
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// unixFormatFor guesses the unit of a Unix timestamp from its magnitude
+func unixFormatFor(intPart int64) TimestampFormat {
+	switch {
+	case intPart < 1e11:
+		return TimestampUnix
+	case intPart < 1e14:
+		return TimestampUnixMilli
+	case intPart < 1e17:
+		return TimestampUnixMicro
+	}
+	return TimestampUnixNano
+}
+
+func isUnixNumber(s string) bool {
+	_, _, _, err := splitDecimal(s)
+	return err == nil
+}
+
+// splitDecimal splits "[-]digits[.digits]" into its absolute integer part
+// and fractional digits
+func splitDecimal(s string) (intPart int64, fraction string, negative bool, err error) {
+	number := s
+	if strings.HasPrefix(number, "-") {
+		negative, number = true, number[1:]
+	}
+	whole, fraction, _ := strings.Cut(number, ".")
+	if whole == "" && fraction == "" {
+		return 0, "", false, fmt.Errorf("invalid timestamp %q", s)
+	}
+	for _, c := range whole + fraction {
+		if c < '0' || c > '9' {
+			return 0, "", false, fmt.Errorf("invalid timestamp %q", s)
+		}
+	}
+	if whole != "" {
+		if intPart, err = strconv.ParseInt(whole, 10, 64); err != nil {
+			return 0, "", false, fmt.Errorf("invalid timestamp %q: out of range", s)
+		}
+	}
+	return intPart, fraction, negative, nil
 }
 
 // CalculateDuration returns the duration between two timestamps
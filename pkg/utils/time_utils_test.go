@@ -48,6 +48,14 @@ func TestIsNightTime(t *testing.T) {
 	}
 }
 
+func TestIsNightTimeIgnoresZone(t *testing.T) {
+	// 2023-01-01 22:00 UTC is 01:30 at UTC+03:30
+	instant := time.Date(2023, 1, 1, 22, 0, 0, 0, time.UTC).In(time.FixedZone("+0330", 12600))
+	if IsNightTime(instant) {
+		t.Errorf("IsNightTime(%v) = true; want false, since it is 22:00 UTC", instant)
+	}
+}
+
 func TestParseTimestamp(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -125,3 +133,75 @@ func TestCalculateDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTimestampString(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) // 1609459200
+	tests := []struct {
+		name          string
+		input         string
+		format        TimestampFormat
+		expected      time.Time
+		expectedError bool
+	}{
+		{"Seconds", "1609459200", TimestampAuto, base, false},
+		{"Fractional seconds", "1609459200.25", TimestampAuto, base.Add(250 * time.Millisecond), false},
+		{"Milliseconds", "1609459200250", TimestampAuto, base.Add(250 * time.Millisecond), false},
+		{"Fractional milliseconds", "1609459200250.5", TimestampAuto, base.Add(250*time.Millisecond + 500*time.Microsecond), false},
+		{"Microseconds", "1609459200250001", TimestampAuto, base.Add(250001 * time.Microsecond), false},
+		{"Nanoseconds", "1609459200250000001", TimestampAuto, base.Add(250000001), false},
+		{"Beyond nanoseconds is truncated", "1609459200.1234567899", TimestampUnix, base.Add(123456789), false},
+		{"Negative", "-1.5", TimestampUnix, time.Unix(-2, 500000000).UTC(), false},
+		{"Explicit unit", "1609459200", TimestampUnixMilli, time.UnixMilli(1609459200).UTC(), false},
+		{"Zero", "0", TimestampAuto, time.Unix(0, 0).UTC(), false},
+		{"RFC 3339 with offset", "2021-01-01T03:30:00.25+03:30", TimestampAuto, base.Add(250 * time.Millisecond), false},
+		{"RFC 3339 with space", "2021-01-01 00:00:00Z", TimestampRFC3339, base, false},
+		{"RFC 3339 required", "1609459200", TimestampRFC3339, time.Time{}, true},
+		{"Not a number", "invalid", TimestampUnix, time.Time{}, true},
+		{"Empty", "", TimestampAuto, time.Time{}, true},
+		{"Lone dot", ".", TimestampAuto, time.Time{}, true},
+		{"Out of range", "99999999999999999999", TimestampAuto, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTimestampString(tt.input, tt.format)
+			if tt.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, but got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Equal(tt.expected) || result.Location() != time.UTC {
+				t.Errorf("ParseTimestampString(%q) = %v; want %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestParseTimestampBytes checks the byte fast path agrees with ParseTimestampString
+func TestParseTimestampBytes(t *testing.T) {
+	inputs := []string{"1609459200", "1609459200250", "1609459200250001", "160945920025000000", "1609459200250000001", "0", "007", "1609459200.5", "-1", "2021-01-01T00:00:00Z", "", "12a"}
+	for _, format := range []TimestampFormat{TimestampAuto, TimestampUnix, TimestampUnixMilli, TimestampUnixMicro, TimestampUnixNano, TimestampRFC3339} {
+		for _, input := range inputs {
+			expected, expectedErr := ParseTimestampString(input, format)
+			result, err := ParseTimestampBytes([]byte(input), format)
+			if (err != nil) != (expectedErr != nil) || !result.Equal(expected) || result.Location() != expected.Location() {
+				t.Errorf("ParseTimestampBytes(%q, %v) = %v, %v; want %v, %v", input, format, result, err, expected, expectedErr)
+			}
+		}
+	}
+}
+
+func TestParseTimestampFormat(t *testing.T) {
+	for name, expected := range map[string]TimestampFormat{"auto": TimestampAuto, "unix_ms": TimestampUnixMilli, "rfc3339": TimestampRFC3339} {
+		if result, err := ParseTimestampFormat(name); err != nil || result != expected {
+			t.Errorf("ParseTimestampFormat(%q) = %v, %v; want %v", name, result, err, expected)
+		}
+	}
+	if _, err := ParseTimestampFormat("excel"); err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}